@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Bridge copies everything written to one pipe into another
+// forwarded messages go to the receivers of the other pipe only so bridges can't loop
+type Bridge struct {
+	from, to       *Pipe
+	fromKey, toKey string
+	// only lines matching the filter are forwarded (nil for everything)
+	filter *regexp.Regexp
+}
+
+// Forward a message written to the source pipe
+func (b *Bridge) Forward(m Message) {
+	if b.filter != nil && !m.system {
+		m.buffer = filterLines(m.buffer, b.filter.Match)
+		if len(m.buffer) < 1 {
+			return
+		}
+	}
+	b.to.deliver(m)
+}
+
+func (b *Bridge) String() string {
+	if b.filter != nil {
+		return fmt.Sprintf("%s -> %s (filter: %s)", b.fromKey, b.toKey, b.filter)
+	}
+	return fmt.Sprintf("%s -> %s", b.fromKey, b.toKey)
+}
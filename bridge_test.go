@@ -0,0 +1,38 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestBridges(t *testing.T) {
+	pipes := MakePipeCollection()
+	job := &TestReceiver{}
+	team := &TestReceiver{}
+	pipes.AddReceiver("job", job)
+	pipes.AddReceiver("team", team)
+
+	if _, err := pipes.AddBridge("job", "missing", nil); err != ErrNoPipe {
+		t.Errorf("Expected missing pipe error: %v", err)
+	}
+	if _, err := pipes.AddBridge("job", "team", regexp.MustCompile("keep")); err != nil {
+		t.Fatalf("Error bridging: %s", err.Error())
+	}
+	pipes.FindPipe("job").Write(Message{fromID: 1, buffer: []byte("keep\ndrop\n")})
+	pipes.FindPipe("team").Write(Message{fromID: 1, buffer: []byte("team only\n")})
+	if team.writer.String() != "keep\nteam only\n" || job.writer.String() != "keep\ndrop\n" {
+		t.Errorf("Invalid bridged data: %q %q", team.writer.String(), job.writer.String())
+	}
+	if stats := pipes.ActiveStats(); stats.BridgeCount != 1 {
+		t.Errorf("Invalid bridge count: %d", stats.BridgeCount)
+	}
+
+	// deleting either pipe removes the bridge
+	pipes.RemoveReceiver("job", job)
+	if len(pipes.Bridges("team")) != 0 || len(pipes.FindPipe("team").bridges) != 0 {
+		t.Errorf("Bridge not removed with the pipe")
+	}
+	if stats := pipes.GlobalStats(); stats.BridgeCount != 1 {
+		t.Errorf("Invalid total bridge count: %d", stats.BridgeCount)
+	}
+}
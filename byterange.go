@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ByteRange is a span of bytes in a file transfer
+// End is the last byte (inclusive) or -1 if open ended
+// Total is the size of the whole file or -1 if unknown
+type ByteRange struct {
+	Start int64
+	End   int64
+	Total int64
+}
+
+// ParseRange reads a single range from a receiver's Range header (bytes=<start>-[<end>])
+// suffix ranges and multiple ranges are not supported and return false
+func ParseRange(header string) (ByteRange, bool) {
+	if !strings.HasPrefix(header, "bytes=") || strings.Contains(header, ",") {
+		return ByteRange{}, false
+	}
+	return parseSpan(strings.TrimPrefix(header, "bytes="), -1)
+}
+
+// ParseContentRange reads a sender's Content-Range header (bytes <start>-<end>/<total|*>)
+func ParseContentRange(header string) (ByteRange, bool) {
+	if !strings.HasPrefix(header, "bytes ") {
+		return ByteRange{}, false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(header, "bytes "), "/", 2)
+	if len(parts) != 2 {
+		return ByteRange{}, false
+	}
+	total := int64(-1)
+	if parts[1] != "*" {
+		var err error
+		total, err = strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || total < 0 {
+			return ByteRange{}, false
+		}
+	}
+	r, ok := parseSpan(parts[0], total)
+	if !ok || r.End < 0 || (total >= 0 && r.End >= total) {
+		return ByteRange{}, false
+	}
+	return r, true
+}
+
+// parse <start>-[<end>]
+func parseSpan(span string, total int64) (ByteRange, bool) {
+	bounds := strings.SplitN(span, "-", 2)
+	if len(bounds) != 2 || len(bounds[0]) == 0 {
+		return ByteRange{}, false
+	}
+	start, err := strconv.ParseInt(bounds[0], 10, 64)
+	if err != nil || start < 0 {
+		return ByteRange{}, false
+	}
+	end := int64(-1)
+	if len(bounds[1]) > 0 {
+		end, err = strconv.ParseInt(bounds[1], 10, 64)
+		if err != nil || end < start {
+			return ByteRange{}, false
+		}
+	}
+	return ByteRange{Start: start, End: end, Total: total}, true
+}
+
+// ContentRange formats the range for a 206 response
+func (r ByteRange) ContentRange() string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.Start, r.End, r.Total)
+}
+
+// Length returns the number of bytes in the range
+func (r ByteRange) Length() int64 {
+	return r.End - r.Start + 1
+}
+
+// unsatisfiedRange formats the Content-Range header for a 416 response
+func unsatisfiedRange(size int64) string {
+	return fmt.Sprintf("bytes */%d", size)
+}
@@ -0,0 +1,58 @@
+package main
+
+import "testing"
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header   string
+		ok       bool
+		expected ByteRange
+	}{
+		{"bytes=100-", true, ByteRange{100, -1, -1}},
+		{"bytes=0-99", true, ByteRange{0, 99, -1}},
+		{"bytes=-100", false, ByteRange{}},
+		{"bytes=0-1,5-6", false, ByteRange{}},
+		{"bytes=9-1", false, ByteRange{}},
+		{"items=0-1", false, ByteRange{}},
+	}
+	for _, test := range tests {
+		actual, ok := ParseRange(test.header)
+		if ok != test.ok || actual != test.expected {
+			t.Errorf("Invalid range for %s: %v %v", test.header, test.expected, actual)
+		}
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		header   string
+		ok       bool
+		expected ByteRange
+	}{
+		{"bytes 100-199/200", true, ByteRange{100, 199, 200}},
+		{"bytes 0-99/*", true, ByteRange{0, 99, -1}},
+		{"bytes 100-200/200", false, ByteRange{}},
+		{"bytes 100-/200", false, ByteRange{}},
+		{"bytes */200", false, ByteRange{}},
+		{"bytes 0-99", false, ByteRange{}},
+	}
+	for _, test := range tests {
+		actual, ok := ParseContentRange(test.header)
+		if ok != test.ok || actual != test.expected {
+			t.Errorf("Invalid content range for %s: %v %v", test.header, test.expected, actual)
+		}
+	}
+}
+
+func TestByteRangeFormat(t *testing.T) {
+	r := ByteRange{Start: 5, End: 9, Total: 10}
+	if r.ContentRange() != "bytes 5-9/10" {
+		t.Errorf("Invalid content range: %s", r.ContentRange())
+	}
+	if r.Length() != 5 {
+		t.Errorf("Invalid range length: %d", r.Length())
+	}
+	if unsatisfiedRange(10) != "bytes */10" {
+		t.Errorf("Invalid unsatisfied range: %s", unsatisfiedRange(10))
+	}
+}
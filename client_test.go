@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jpschroeder/pipe-to-me/pipetome"
+)
+
+func TestClientSendReceive(t *testing.T) {
+	_, ts := makeTestServer()
+	defer ts.Close()
+	url := ts.URL + "/clientkey"
+	ctx := context.Background()
+
+	body, err := pipetome.Receive(ctx, url)
+	if err != nil {
+		t.Fatalf("Error receiving: %s", err.Error())
+	}
+	defer body.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	input := "client test data"
+	if err := pipetome.Send(ctx, url, strings.NewReader(input)); err != nil {
+		t.Fatalf("Error sending: %s", err.Error())
+	}
+	output, _ := io.ReadAll(body)
+	if string(output) != input {
+		t.Errorf("Invalid data received: %q %q", input, string(output))
+	}
+}
+
+func TestClientFailMode(t *testing.T) {
+	_, ts := makeTestServer()
+	defer ts.Close()
+	url := ts.URL + "/failkey"
+	ctx := context.Background()
+
+	err := pipetome.Send(ctx, url, strings.NewReader("data"), pipetome.WithMode(pipetome.ModeFail))
+	if !errors.Is(err, pipetome.ErrNoReceivers) {
+		t.Errorf("Invalid send error: %v", err)
+	}
+	var statusErr *pipetome.StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != 417 {
+		t.Errorf("Invalid status error: %v", err)
+	}
+
+	_, err = pipetome.Receive(ctx, url, pipetome.WithMode(pipetome.ModeFail))
+	if !errors.Is(err, pipetome.ErrNoSenders) {
+		t.Errorf("Invalid receive error: %v", err)
+	}
+}
+
+func TestClientDial(t *testing.T) {
+	_, ts := makeTestServer()
+	defer ts.Close()
+	url := ts.URL + "/dialkey"
+	ctx := context.Background()
+
+	first, err := pipetome.Dial(ctx, url, pipetome.WithMode(pipetome.ModeInteractive), pipetome.WithUser("first", ""))
+	if err != nil {
+		t.Fatalf("Error dialing first: %s", err.Error())
+	}
+	defer first.Close()
+	second, err := pipetome.Dial(ctx, url, pipetome.WithMode(pipetome.ModeInteractive), pipetome.WithUser("second", ""))
+	if err != nil {
+		t.Fatalf("Error dialing second: %s", err.Error())
+	}
+	defer second.Close()
+
+	// each connection sees the data written by the other
+	firstLines := bufio.NewReader(first)
+	secondLines := bufio.NewReader(second)
+	if _, err := first.Write([]byte("hello\n")); err != nil {
+		t.Fatalf("Error writing: %s", err.Error())
+	}
+	line, err := readUntil(secondLines, "hello")
+	if err != nil || !strings.Contains(line, "first: hello") {
+		t.Errorf("Invalid message received by second: %q %v", line, err)
+	}
+	if _, err := second.Write([]byte("hi\n")); err != nil {
+		t.Fatalf("Error writing: %s", err.Error())
+	}
+	line, err = readUntil(firstLines, "hi")
+	if err != nil || !strings.Contains(line, "second: hi") {
+		t.Errorf("Invalid message received by first: %q %v", line, err)
+	}
+}
+
+// read lines (skipping system messages) until one contains the text
+func readUntil(r *bufio.Reader, text string) (string, error) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil || strings.Contains(line, text) {
+			return line, err
+		}
+	}
+}
+
+func TestClientNew(t *testing.T) {
+	_, ts := makeTestServer()
+	defer ts.Close()
+
+	url, err := pipetome.New(context.Background(), ts.URL)
+	if err != nil {
+		t.Fatalf("Error creating pipe: %s", err.Error())
+	}
+	if !strings.HasPrefix(url, ts.URL+"/") || len(url) != len(ts.URL)+1+keySize {
+		t.Errorf("Invalid new url: %s", url)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// the server used when a dotfile doesn't set one
+const defaultServer = "https://pipeto.me/"
+
+// config holds the defaults read from the dotfile
+type config struct {
+	server string
+	user   string
+}
+
+// configPath returns $PIPERC or ~/.piperc
+func configPath() string {
+	if path := os.Getenv("PIPERC"); len(path) > 0 {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".piperc")
+}
+
+// loadConfig reads the dotfile - a missing file just uses the defaults
+func loadConfig(path string) (config, error) {
+	c := config{server: defaultServer}
+	if len(path) == 0 {
+		return c, nil
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	defer f.Close()
+	return parseConfig(f, c)
+}
+
+// parseConfig reads "name = value" lines, ignoring blank lines and # comments
+func parseConfig(r io.Reader, c config) (config, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(parts[1])
+		switch strings.TrimSpace(parts[0]) {
+		case "server":
+			c.server = value
+		case "user":
+			c.user = value
+		}
+	}
+	return c, scanner.Err()
+}
+
+// pipeURL turns a key into a url on the configured server
+// full urls are used as-is
+func (c config) pipeURL(keyOrURL string) string {
+	if strings.Contains(keyOrURL, "://") {
+		return keyOrURL
+	}
+	return strings.TrimSuffix(c.server, "/") + "/" + strings.TrimPrefix(keyOrURL, "/")
+}
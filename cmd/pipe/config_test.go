@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	input := `
+# defaults for the pipe client
+server = https://example.com/pipes/
+user=me
+unknown = ignored
+`
+	c, err := parseConfig(strings.NewReader(input), config{server: defaultServer})
+	if err != nil {
+		t.Fatalf("Error parsing config: %s", err.Error())
+	}
+	if c.server != "https://example.com/pipes/" || c.user != "me" {
+		t.Errorf("Invalid config: %+v", c)
+	}
+
+	c, _ = parseConfig(strings.NewReader(""), config{server: defaultServer})
+	if c.server != defaultServer || len(c.user) > 0 {
+		t.Errorf("Invalid default config: %+v", c)
+	}
+}
+
+func TestPipeURL(t *testing.T) {
+	c := config{server: "https://example.com/pipes"}
+	tests := map[string]string{
+		"abcd1234":                   "https://example.com/pipes/abcd1234",
+		"/abcd1234":                  "https://example.com/pipes/abcd1234",
+		"https://pipeto.me/abcd1234": "https://pipeto.me/abcd1234",
+	}
+	for input, expected := range tests {
+		if actual := c.pipeURL(input); actual != expected {
+			t.Errorf("Invalid pipe url for %s: %s %s", input, expected, actual)
+		}
+	}
+}
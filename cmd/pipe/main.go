@@ -0,0 +1,345 @@
+// pipe is a command line client for pipe-to-me servers
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/jpschroeder/pipe-to-me/pipetome"
+)
+
+// how long to wait before reconnecting a dropped chat
+const reconnectDelay = 2 * time.Second
+
+const usage = `usage: pipe <command> [arguments]
+
+commands:
+    new                                     create a new pipe url
+    send [-mode m] [-u user] <url> [file]   send a file or stdin to a pipe
+    recv [-mode m] [-o file] <url>          receive from a pipe to stdout or a file
+    chat [-u user] <url>                    chat with everyone connected to a pipe
+    share [-mode m] [url] -- <command>      send the output of a command to a pipe
+
+<url> can be a full url or just the key of a pipe on the configured server.
+modes: fail, block, interactive, file
+
+defaults are read from ~/.piperc (or $PIPERC):
+    server = https://pipeto.me/
+    user = name
+`
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "--help" {
+		fmt.Print(usage)
+		return
+	}
+	c, err := loadConfig(configPath())
+	if err != nil {
+		fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	args := os.Args[2:]
+	switch os.Args[1] {
+	case "new":
+		err = newPipe(ctx, c)
+	case "send":
+		err = send(ctx, c, args)
+	case "recv":
+		err = recv(ctx, c, args)
+	case "chat":
+		err = chat(ctx, c, args)
+	case "share":
+		err = share(ctx, c, args)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil && ctx.Err() == nil {
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "pipe: %s\n", err)
+	os.Exit(1)
+}
+
+// parse the subcommand flags and exit with usage if the number of arguments is wrong
+// flags can come before or after the arguments (pipe recv <url> -o file)
+// a -- and everything after it is returned as-is
+func parseFlags(fs *flag.FlagSet, args []string, min, max int) []string {
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+	}
+	var positional []string
+	for len(args) > 0 && args[0] != "--" {
+		fs.Parse(args)
+		rest := fs.Args()
+		// the flag package removes a -- that ends the flags
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			args = append([]string{"--"}, rest...)
+			break
+		}
+		if len(rest) == 0 {
+			args = rest
+			break
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+	positional = append(positional, args...)
+	if len(positional) < min || len(positional) > max {
+		fs.Usage()
+		os.Exit(2)
+	}
+	return positional
+}
+
+// create a new pipe on the configured server
+func newPipe(ctx context.Context, c config) error {
+	pipeURL, err := pipetome.New(ctx, c.server)
+	if err != nil {
+		return err
+	}
+	fmt.Println(pipeURL)
+	return nil
+}
+
+// send a file or stdin to a pipe
+func send(ctx context.Context, c config, args []string) error {
+	fs := flag.NewFlagSet("send", flag.ExitOnError)
+	mode := fs.String("mode", "", "the mode to send with")
+	user := fs.String("u", c.user, "the username to send as")
+	args = parseFlags(fs, args, 1, 2)
+	pipeURL := c.pipeURL(args[0])
+
+	var input io.Reader = os.Stdin
+	total := int64(-1)
+	if len(args) == 2 {
+		f, err := os.Open(args[1])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if info, err := f.Stat(); err == nil {
+			total = info.Size()
+		}
+		input = f
+		// receivers in file mode are told the filename
+		if pipetome.Mode(*mode) == pipetome.ModeFile {
+			pipeURL = withQuery(pipeURL, "filename", filepath.Base(args[1]))
+		}
+	}
+
+	// only draw the progress bar for a terminal
+	if isTerminal(os.Stderr) {
+		progress := makeProgressReader(input, os.Stderr, total)
+		defer progress.Done()
+		input = progress
+	}
+	return pipetome.Send(ctx, pipeURL, input, pipetome.WithMode(pipetome.Mode(*mode)), pipetome.WithUser(*user, ""),
+		pipetome.WithContentLength(total))
+}
+
+// receive from a pipe to stdout or a file
+func recv(ctx context.Context, c config, args []string) error {
+	fs := flag.NewFlagSet("recv", flag.ExitOnError)
+	mode := fs.String("mode", "", "the mode to receive with")
+	output := fs.String("o", "", "the file to write to instead of stdout")
+	args = parseFlags(fs, args, 1, 1)
+
+	body, err := pipetome.Receive(ctx, c.pipeURL(args[0]), pipetome.WithMode(pipetome.Mode(*mode)))
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	var out io.Writer = os.Stdout
+	if len(*output) > 0 {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	_, err = io.Copy(out, body)
+	return err
+}
+
+// chat with everyone on a pipe in interactive mode
+// lines are edited by the terminal and sent when enter is pressed
+// the connection is re-established if it drops
+func chat(ctx context.Context, c config, args []string) error {
+	fs := flag.NewFlagSet("chat", flag.ExitOnError)
+	user := fs.String("u", c.user, "the username to chat as")
+	args = parseFlags(fs, args, 1, 1)
+	pipeURL := c.pipeURL(args[0])
+
+	lines := make(chan []byte)
+	go readLines(os.Stdin, lines)
+
+	for {
+		conn, err := pipetome.Dial(ctx, pipeURL, pipetome.WithMode(pipetome.ModeInteractive), pipetome.WithUser(*user, ""))
+		var statusErr *pipetome.StatusError
+		if errors.As(err, &statusErr) || ctx.Err() != nil {
+			return err
+		}
+		if err == nil {
+			finished := chatSession(ctx, conn, lines)
+			conn.Close()
+			if finished {
+				return nil
+			}
+		}
+		fmt.Fprintln(os.Stderr, "disconnected - reconnecting")
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+// relay lines to the connection until stdin ends (true) or the connection drops (false)
+func chatSession(ctx context.Context, conn *pipetome.Conn, lines <-chan []byte) bool {
+	closed := make(chan bool)
+	go func() {
+		io.Copy(os.Stdout, conn)
+		close(closed)
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return true
+		case <-closed:
+			return false
+		case line, ok := <-lines:
+			if !ok {
+				return true
+			}
+			if _, err := conn.Write(line); err != nil {
+				return false
+			}
+		}
+	}
+}
+
+// read whole lines so that a reconnect doesn't split a message
+func readLines(r io.Reader, lines chan<- []byte) {
+	defer close(lines)
+	buf := make([]byte, 4096)
+	var line []byte
+	for {
+		n, err := r.Read(buf)
+		for _, b := range buf[:n] {
+			line = append(line, b)
+			if b == '\n' {
+				lines <- line
+				line = nil
+			}
+		}
+		if err != nil {
+			if len(line) > 0 {
+				lines <- line
+			}
+			return
+		}
+	}
+}
+
+// run a command and send its output to a pipe
+// a new pipe is created if no url is given
+func share(ctx context.Context, c config, args []string) error {
+	fs := flag.NewFlagSet("share", flag.ExitOnError)
+	mode := fs.String("mode", "", "the mode to send with")
+	user := fs.String("u", c.user, "the username to send as")
+	args = parseFlags(fs, args, 1, len(args))
+
+	key, command, ok := splitCommand(args)
+	if !ok || len(command) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var pipeURL string
+	if len(key) > 0 {
+		pipeURL = c.pipeURL(key)
+	} else {
+		var err error
+		if pipeURL, err = pipetome.New(ctx, c.server); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(os.Stderr, "sharing to: %s\n", pipeURL)
+
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	// the output is still shown locally
+	sendErr := pipetome.Send(ctx, pipeURL, io.TeeReader(stdout, os.Stdout), pipetome.WithMode(pipetome.Mode(*mode)), pipetome.WithUser(*user, ""))
+	if sendErr != nil {
+		// nothing is reading the output anymore
+		cmd.Process.Kill()
+		cmd.Wait()
+		return sendErr
+	}
+	if err := cmd.Wait(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// split "[url] -- command [args...]" into the url and the command
+func splitCommand(args []string) (string, []string, bool) {
+	for i, arg := range args {
+		if arg == "--" {
+			if i > 1 {
+				return "", nil, false
+			}
+			key := ""
+			if i == 1 {
+				key = args[0]
+			}
+			return key, args[i+1:], true
+		}
+	}
+	return "", args, true
+}
+
+// add a query parameter to a url
+func withQuery(rawURL, name, value string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	query := u.Query()
+	query.Set(name, value)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// isTerminal returns whether a file is an interactive terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestParseFlags(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	output := fs.String("o", "", "")
+	args := parseFlags(fs, []string{"abcd1234", "-o", "out.txt"}, 1, 1)
+	if strings.Join(args, " ") != "abcd1234" || *output != "out.txt" {
+		t.Errorf("Invalid flags after arguments: %v %s", args, *output)
+	}
+
+	fs = flag.NewFlagSet("test", flag.ExitOnError)
+	mode := fs.String("mode", "", "")
+	args = parseFlags(fs, []string{"-mode", "fail", "--", "ls", "-la"}, 1, 5)
+	if strings.Join(args, " ") != "-- ls -la" || *mode != "fail" {
+		t.Errorf("Invalid flags before --: %v %s", args, *mode)
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	key, command, ok := splitCommand([]string{"abcd1234", "--", "ls", "-la"})
+	if !ok || key != "abcd1234" || strings.Join(command, " ") != "ls -la" {
+		t.Errorf("Invalid split with key: %s %v %t", key, command, ok)
+	}
+	key, command, ok = splitCommand([]string{"ls", "-la"})
+	if !ok || len(key) > 0 || strings.Join(command, " ") != "ls -la" {
+		t.Errorf("Invalid split without key: %s %v %t", key, command, ok)
+	}
+	if _, _, ok := splitCommand([]string{"a", "b", "--", "ls"}); ok {
+		t.Error("Expected multiple urls to fail")
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// the width of the progress bar in characters
+const barWidth = 30
+
+// progressReader reports how much of an upload has been read
+type progressReader struct {
+	reader io.Reader
+	out    io.Writer
+	total  int64 // -1 if unknown
+	read   int64
+	last   time.Time
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.reader.Read(b)
+	p.read += int64(n)
+	// redraw at most 10 times a second
+	if err != nil || time.Since(p.last) > 100*time.Millisecond {
+		p.last = time.Now()
+		fmt.Fprintf(p.out, "\r%s", progressLine(p.read, p.total))
+	}
+	return n, err
+}
+
+// Done ends the progress line
+func (p *progressReader) Done() {
+	fmt.Fprintf(p.out, "\r%s\n", progressLine(p.read, p.total))
+}
+
+// progressLine renders the progress bar or just the byte count if the size is unknown
+func progressLine(read, total int64) string {
+	if total <= 0 {
+		return fmt.Sprintf("%s sent", formatBytes(read))
+	}
+	if read > total {
+		read = total
+	}
+	filled := int(read * barWidth / total)
+	return fmt.Sprintf("[%s%s] %3d%% %s/%s",
+		strings.Repeat("#", filled),
+		strings.Repeat(" ", barWidth-filled),
+		read*100/total,
+		formatBytes(read),
+		formatBytes(total))
+}
+
+// formatBytes prints a size with binary units
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func makeProgressReader(r io.Reader, out io.Writer, total int64) *progressReader {
+	return &progressReader{reader: r, out: out, total: total}
+}
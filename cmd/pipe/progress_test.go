@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestProgressLine(t *testing.T) {
+	tests := []struct {
+		read, total int64
+		expected    string
+	}{
+		{0, 100, "[                              ]   0% 0 B/100 B"},
+		{50, 100, "[###############               ]  50% 50 B/100 B"},
+		{2048, 2048, "[##############################] 100% 2.0 KB/2.0 KB"},
+		{1536, -1, "1.5 KB sent"},
+		{3 * 1024 * 1024, 0, "3.0 MB sent"},
+	}
+	for _, test := range tests {
+		if actual := progressLine(test.read, test.total); actual != test.expected {
+			t.Errorf("Invalid progress line: %q %q", test.expected, actual)
+		}
+	}
+}
+
+func TestProgressReader(t *testing.T) {
+	var out bytes.Buffer
+	progress := makeProgressReader(strings.NewReader("0123456789"), &out, 10)
+	data, _ := io.ReadAll(progress)
+	progress.Done()
+	if string(data) != "0123456789" {
+		t.Errorf("Invalid data read: %s", string(data))
+	}
+	if !strings.HasSuffix(out.String(), "100% 10 B/10 B\n") {
+		t.Errorf("Invalid progress output: %q", out.String())
+	}
+}
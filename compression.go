@@ -0,0 +1,129 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// acceptsGzip returns whether the client accepts gzip encoded responses
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		fields := strings.Split(part, ";")
+		if strings.TrimSpace(fields[0]) != "gzip" {
+			continue
+		}
+		// gzip;q=0 means not acceptable
+		for _, field := range fields[1:] {
+			field = strings.TrimSpace(field)
+			if strings.HasPrefix(field, "q=") {
+				q, err := strconv.ParseFloat(strings.TrimPrefix(field, "q="), 64)
+				return err == nil && q > 0
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// isGzip returns whether a Content-Encoding is gzip
+// returns an error for encodings that aren't supported
+func isGzip(encoding string) (bool, error) {
+	switch strings.ToLower(encoding) {
+	case "", "identity":
+		return false, nil
+	case "gzip", "x-gzip":
+		return true, nil
+	}
+	return false, http.ErrNotSupported
+}
+
+// GzipResponseWriter compresses a streamed response
+// each flush writes out everything compressed so far so that streaming latency doesn't regress
+type GzipResponseWriter struct {
+	http.ResponseWriter
+	gz      *gzip.Writer
+	flusher http.Flusher
+}
+
+// WriteHeader sends the response headers - the length is no longer known after compression
+func (g *GzipResponseWriter) WriteHeader(code int) {
+	g.Header().Del("Content-Length")
+	g.ResponseWriter.WriteHeader(code)
+}
+
+// Write compresses the buffer into the response
+func (g *GzipResponseWriter) Write(p []byte) (int, error) {
+	return g.gz.Write(p)
+}
+
+// Flush the compressed data back to the client
+func (g *GzipResponseWriter) Flush() {
+	g.gz.Flush()
+	if g.flusher != nil {
+		g.flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying response so that http.ResponseController can reach the connection
+func (g *GzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+// Close writes the end of the compressed stream
+func (g *GzipResponseWriter) Close() error {
+	err := g.gz.Close()
+	if g.flusher != nil {
+		g.flusher.Flush()
+	}
+	return err
+}
+
+// compressResponse wraps the response in gzip if the client accepts it
+// the returned function must be called to finish the response
+func compressResponse(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func()) {
+	w.Header().Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(r) {
+		return w, func() {}
+	}
+	w.Header().Set("Content-Encoding", "gzip")
+	flusher, _ := w.(http.Flusher)
+	g := &GzipResponseWriter{
+		ResponseWriter: w,
+		gz:             gzip.NewWriter(w),
+		flusher:        flusher,
+	}
+	return g, func() { g.Close() }
+}
+
+// lazyGzipReader decompresses an upload
+// the gzip header isn't read until the first read so that the handler isn't blocked waiting for it
+type lazyGzipReader struct {
+	reader io.Reader
+	gz     *gzip.Reader
+}
+
+func (l *lazyGzipReader) Read(p []byte) (int, error) {
+	if l.gz == nil {
+		gz, err := gzip.NewReader(l.reader)
+		if err != nil {
+			return 0, err
+		}
+		l.gz = gz
+	}
+	return l.gz.Read(p)
+}
+
+// decompressBody returns the plain request body
+func decompressBody(r *http.Request) (io.Reader, error) {
+	compressed, err := isGzip(r.Header.Get("Content-Encoding"))
+	if err != nil {
+		return nil, err
+	}
+	if compressed {
+		return &lazyGzipReader{reader: r.Body}, nil
+	}
+	return r.Body, nil
+}
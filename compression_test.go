@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"deflate, gzip;q=1.0, *;q=0.5", true},
+		{"br, gzip;q=0", false},
+		{"identity", false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/key", nil)
+		r.Header.Set("Accept-Encoding", test.header)
+		if acceptsGzip(r) != test.expected {
+			t.Errorf("Invalid gzip acceptance for %q: %v", test.header, test.expected)
+		}
+	}
+}
+
+func TestGzipResponseFlush(t *testing.T) {
+	r := httptest.NewRequest("GET", "/key", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	recorder := httptest.NewRecorder()
+	w, done := compressResponse(recorder, r)
+
+	w.Write([]byte("test input"))
+	w.(http.Flusher).Flush()
+
+	// everything written so far can be decompressed before the stream is finished
+	gz, err := gzip.NewReader(bytes.NewReader(recorder.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("Error reading flushed gzip: %s", err.Error())
+	}
+	buffer := make([]byte, len("test input"))
+	if _, err := io.ReadFull(gz, buffer); err != nil || string(buffer) != "test input" {
+		t.Errorf("Invalid flushed data: %s %v", string(buffer), err)
+	}
+
+	done()
+	if recorder.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("Content-Encoding not set")
+	}
+}
+
+func gzipString(s string) []byte {
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	gz.Write([]byte(s))
+	gz.Close()
+	return b.Bytes()
+}
+
+func TestCompressedStream(t *testing.T) {
+	_, ts := makeTestServer()
+	defer ts.Close()
+	url := ts.URL + "/gzipkey"
+	// a client that doesn't ask for or decode compression
+	plain := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+
+	type result struct {
+		encoding string
+		body     []byte
+	}
+	receive := func(acceptGzip bool, results chan result) {
+		req, _ := http.NewRequest("GET", url, nil)
+		if acceptGzip {
+			req.Header.Set("Accept-Encoding", "gzip")
+		}
+		resp, err := plain.Do(req)
+		if err != nil {
+			results <- result{}
+			return
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		results <- result{resp.Header.Get("Content-Encoding"), body}
+	}
+	plainResults := make(chan result)
+	gzipResults := make(chan result)
+	go receive(false, plainResults)
+	go receive(true, gzipResults)
+	time.Sleep(50 * time.Millisecond)
+
+	req, _ := http.NewRequest("PUT", url, bytes.NewReader(gzipString("test input")))
+	req.Header.Set("Content-Encoding", "gzip")
+	resp, err := plain.Do(req)
+	if err != nil {
+		t.Fatalf("Error sending: %s", err.Error())
+	}
+	resp.Body.Close()
+
+	r := <-plainResults
+	if r.encoding != "" || string(r.body) != "test input" {
+		t.Errorf("Invalid plain receiver: %s %s", r.encoding, string(r.body))
+	}
+	r = <-gzipResults
+	if r.encoding != "gzip" {
+		t.Errorf("Invalid gzip receiver encoding: %s", r.encoding)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(r.body))
+	if err != nil {
+		t.Fatalf("Error reading gzip receiver: %s", err.Error())
+	}
+	if body, _ := io.ReadAll(gz); string(body) != "test input" {
+		t.Errorf("Invalid gzip receiver: %s", string(body))
+	}
+}
+
+func TestUnsupportedEncoding(t *testing.T) {
+	s, ts := makeTestServer()
+	defer ts.Close()
+
+	req, _ := http.NewRequest("PUT", ts.URL+"/brkey", strings.NewReader("test input"))
+	req.Header.Set("Content-Encoding", "br")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error sending: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("Invalid status for unsupported encoding: %d", resp.StatusCode)
+	}
+	// the refused upload doesn't leave an empty pipe behind
+	if s.allPipes.FindPipe("brkey") != nil {
+		t.Errorf("Pipe should not be created for a refused upload")
+	}
+}
+
+func TestCompressedFilePassthrough(t *testing.T) {
+	_, ts := makeTestServer()
+	defer ts.Close()
+	plain := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	compressed := gzipString("test input")
+
+	for _, acceptGzip := range []bool{true, false} {
+		url := fmt.Sprintf("%s/gzipfile%v?mode=file", ts.URL, acceptGzip)
+		received := make(chan []byte)
+		go func(acceptGzip bool) {
+			req, _ := http.NewRequest("GET", url, nil)
+			if acceptGzip {
+				req.Header.Set("Accept-Encoding", "gzip")
+			}
+			resp, err := plain.Do(req)
+			if err != nil {
+				received <- nil
+				return
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			received <- body
+		}(acceptGzip)
+		time.Sleep(50 * time.Millisecond)
+
+		req, _ := http.NewRequest("PUT", url, bytes.NewReader(compressed))
+		req.Header.Set("Content-Encoding", "gzip")
+		resp, err := plain.Do(req)
+		if err != nil {
+			t.Fatalf("Error sending: %s", err.Error())
+		}
+		sent, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if !strings.HasPrefix(string(sent), "delivered") {
+			t.Errorf("File not delivered: %s", string(sent))
+		}
+
+		body := <-received
+		if acceptGzip && !bytes.Equal(body, compressed) {
+			t.Errorf("Compressed file not passed through untouched")
+		}
+		if !acceptGzip && string(body) != "test input" {
+			t.Errorf("Invalid decompressed file: %s", string(body))
+		}
+	}
+}
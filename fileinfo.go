@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// FileInfo describes the data a sender is uploading
+// it is passed on to receivers in file mode so that browsers can save the file correctly
+type FileInfo struct {
+	ContentType     string
+	ContentLength   int64 // -1 if unknown
+	ContentEncoding string
+	Filename        string
+}
+
+// ParseFileInfo reads the file description from a sender request
+// a ?filename= query parameter takes precedence over the Content-Disposition header
+func ParseFileInfo(r *http.Request, filename string) FileInfo {
+	if len(filename) == 0 {
+		if _, params, err := mime.ParseMediaType(r.Header.Get("Content-Disposition")); err == nil {
+			filename = params["filename"]
+		}
+	}
+	return FileInfo{
+		ContentType:     r.Header.Get("Content-Type"),
+		ContentLength:   r.ContentLength,
+		ContentEncoding: r.Header.Get("Content-Encoding"),
+		Filename:        cleanFilename(filename),
+	}
+}
+
+// WriteHeaders sets the response headers for a receiver of the file
+// the sender picks the content type so browsers are always told to save the file rather than render it
+func (f FileInfo) WriteHeaders(h http.Header) {
+	if len(f.ContentType) > 0 {
+		h.Set("Content-Type", f.ContentType)
+	}
+	if f.ContentLength >= 0 {
+		h.Set("Content-Length", strconv.FormatInt(f.ContentLength, 10))
+	}
+	if len(f.Filename) > 0 {
+		h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": f.Filename}))
+	} else {
+		h.Set("Content-Disposition", "attachment")
+	}
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Content-Security-Policy", "sandbox")
+}
+
+func (f FileInfo) String() string {
+	return fmt.Sprintf("%s (%s) %d bytes", f.Filename, f.ContentType, f.ContentLength)
+}
+
+// strip any directories from a filename supplied by a client
+func cleanFilename(filename string) string {
+	filename = path.Base(strings.Replace(filename, "\\", "/", -1))
+	if filename == "." || filename == "/" {
+		return ""
+	}
+	return filename
+}
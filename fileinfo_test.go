@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParseFileInfo(t *testing.T) {
+	r, _ := http.NewRequest("PUT", "/key", strings.NewReader("test input"))
+	r.Header.Set("Content-Type", "application/pdf")
+	r.Header.Set("Content-Disposition", `attachment; filename="report.pdf"`)
+
+	info := ParseFileInfo(r, "")
+	if info.ContentType != "application/pdf" {
+		t.Errorf("Invalid content type: %s", info.ContentType)
+	}
+	if info.ContentLength != int64(len("test input")) {
+		t.Errorf("Invalid content length: %d", info.ContentLength)
+	}
+	if info.Filename != "report.pdf" {
+		t.Errorf("Invalid filename: %s", info.Filename)
+	}
+
+	info = ParseFileInfo(r, "../../etc/override.pdf")
+	if info.Filename != "override.pdf" {
+		t.Errorf("Invalid filename override: %s", info.Filename)
+	}
+}
+
+func TestFileInfoHeaders(t *testing.T) {
+	h := http.Header{}
+	FileInfo{ContentType: "image/png", ContentLength: 42, Filename: "a b.png"}.WriteHeaders(h)
+
+	if h.Get("Content-Type") != "image/png" {
+		t.Errorf("Invalid content type header: %s", h.Get("Content-Type"))
+	}
+	if h.Get("Content-Length") != "42" {
+		t.Errorf("Invalid content length header: %s", h.Get("Content-Length"))
+	}
+	if h.Get("Content-Disposition") != `attachment; filename="a b.png"` {
+		t.Errorf("Invalid content disposition header: %s", h.Get("Content-Disposition"))
+	}
+	if h.Get("X-Content-Type-Options") != "nosniff" || h.Get("Content-Security-Policy") != "sandbox" {
+		t.Errorf("Downloads should not be rendered by the browser: %v", h)
+	}
+
+	// files without a name are still downloaded rather than rendered
+	h = http.Header{}
+	FileInfo{ContentLength: -1}.WriteHeaders(h)
+	if len(h.Get("Content-Type")) > 0 || len(h.Get("Content-Length")) > 0 {
+		t.Errorf("Unknown values should not be written: %v", h)
+	}
+	if h.Get("Content-Disposition") != "attachment" || h.Get("Content-Security-Policy") != "sandbox" {
+		t.Errorf("Invalid headers for an unnamed file: %v", h)
+	}
+}
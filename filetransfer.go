@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// how long an incomplete file transfer is kept for the sender or receiver to resume
+	resumeTimeout = time.Hour
+	// how long the key of a finished file transfer can't be reused
+	retiredTimeout = 24 * time.Hour
+)
+
+var (
+	// ErrSlotTaken is returned when a one-shot file pipe already has a sender or receiver
+	ErrSlotTaken = errors.New("pipe already in use")
+	// ErrKeyRetired is returned when a one-shot file pipe has already been used
+	ErrKeyRetired = errors.New("pipe has been retired")
+)
+
+// FileTransfer holds the state of a one-shot file pipe
+// it admits exactly one sender and one receiver
+type FileTransfer struct {
+	// guards the transfer - the sender, receiver and collection all use it
+	mu       sync.Mutex
+	sender   bool // the sender slot has been claimed
+	receiver bool // the receiver slot has been claimed
+	// the data uploaded so far (nil until a sender connects)
+	spool *Spool
+	// the size of the whole file or -1 if the sender didn't say
+	total int64
+	// the receiver has read the whole file
+	complete bool
+	// when the last sender or receiver disconnected
+	lastActive time.Time
+	// the receiver reports the result of the transfer
+	done chan error
+	// closed if the transfer is thrown away before it completes
+	aborted chan bool
+}
+
+// Claim reserves the sender or receiver slot
+func (t *FileTransfer) Claim(sender bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if sender {
+		if t.sender {
+			return ErrSlotTaken
+		}
+		t.sender = true
+		return nil
+	}
+	if t.receiver {
+		return ErrSlotTaken
+	}
+	t.receiver = true
+	return nil
+}
+
+// Release frees a previously claimed slot
+func (t *FileTransfer) Release(sender bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if sender {
+		t.sender = false
+	} else {
+		t.receiver = false
+	}
+	t.lastActive = time.Now()
+}
+
+// Claimed returns whether either of the slots is reserved
+func (t *FileTransfer) Claimed() bool {
+	if t == nil {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.sender || t.receiver
+}
+
+// OpenSpool creates the buffer for the uploaded data if it doesn't exist yet
+func (t *FileTransfer) OpenSpool(dir string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.spool != nil {
+		return nil
+	}
+	spool, err := MakeSpool(dir)
+	if err != nil {
+		return err
+	}
+	t.spool = spool
+	return nil
+}
+
+// Spool returns the buffer for the uploaded data or nil if no sender has connected
+func (t *FileTransfer) Spool() *Spool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.spool
+}
+
+// Pending returns whether data has been uploaded that hasn't been delivered yet
+func (t *FileTransfer) Pending() bool {
+	if t == nil {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.spool != nil && !t.complete
+}
+
+// SetTotal records the size of the whole file - only the first known size is used
+func (t *FileTransfer) SetTotal(total int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.total < 0 && total >= 0 {
+		t.total = total
+	}
+}
+
+// Total returns the size of the whole file or -1 if unknown
+func (t *FileTransfer) Total() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.total
+}
+
+// Finish is called by the receiver when it has read the whole file
+func (t *FileTransfer) Finish(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err == nil {
+		t.complete = true
+	}
+	// non-blocking
+	select {
+	case t.done <- err:
+	default:
+	}
+}
+
+// Complete returns whether the receiver has read the whole file
+func (t *FileTransfer) Complete() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.complete
+}
+
+// Done returns a notification channel with the result from the receiver
+func (t *FileTransfer) Done() <-chan error {
+	return t.done
+}
+
+// Expired returns whether an abandoned transfer has waited too long to be resumed
+func (t *FileTransfer) Expired(now time.Time) bool {
+	if t.Claimed() || !t.Pending() {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return now.Sub(t.lastActive) > resumeTimeout
+}
+
+// Discard throws away the uploaded data and tells anyone waiting that the transfer is over
+func (t *FileTransfer) Discard() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	select {
+	case <-t.aborted:
+	default:
+		close(t.aborted)
+	}
+	if t.spool != nil {
+		t.spool.Discard()
+	}
+}
+
+// Aborted returns a notification channel that is closed if the transfer is discarded
+func (t *FileTransfer) Aborted() <-chan bool {
+	return t.aborted
+}
+
+// MakeFileTransfer creates the state for a new one-shot file pipe
+func MakeFileTransfer() *FileTransfer {
+	return &FileTransfer{
+		total:      -1,
+		lastActive: time.Now(),
+		done:       make(chan error, 1),
+		aborted:    make(chan bool),
+	}
+}
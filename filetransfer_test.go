@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFileTransferClaim(t *testing.T) {
+	transfer := MakeFileTransfer()
+	if transfer.Claimed() {
+		t.Errorf("New transfer should not be claimed")
+	}
+	if err := transfer.Claim(true); err != nil {
+		t.Errorf("Error claiming sender: %s", err.Error())
+	}
+	if err := transfer.Claim(true); err != ErrSlotTaken {
+		t.Errorf("Second sender should be rejected: %v", err)
+	}
+	if err := transfer.Claim(false); err != nil {
+		t.Errorf("Error claiming receiver: %s", err.Error())
+	}
+	transfer.Release(true)
+	transfer.Release(false)
+	if transfer.Claimed() {
+		t.Errorf("Released transfer should not be claimed")
+	}
+}
+
+func TestCollectionRetireFile(t *testing.T) {
+	pipes := MakePipeCollection()
+
+	pipe, err := pipes.ClaimFile("key", false)
+	if err != nil {
+		t.Fatalf("Error claiming file pipe: %s", err.Error())
+	}
+	if !pipes.IsFilePipe("key") {
+		t.Errorf("Pipe should be in file mode")
+	}
+
+	// released before the transfer started - the key can be reused
+	pipes.ReleaseFile("key", pipe, false)
+	if pipes.IsRetired("key") || pipes.IsFilePipe("key") {
+		t.Errorf("Unused file pipe should be deleted and not retired")
+	}
+
+	// an incomplete upload is kept so that it can be resumed
+	pipe, _ = pipes.ClaimFile("key", true)
+	pipe.Transfer().OpenSpool("")
+	pipe.Transfer().Spool().Write([]byte("test input"))
+	pipes.ReleaseFile("key", pipe, true)
+	if pipes.IsRetired("key") || !pipes.IsFilePipe("key") {
+		t.Errorf("Incomplete file pipe should be kept for resuming")
+	}
+
+	pipe, _ = pipes.ClaimFile("key", false)
+	pipe.Transfer().Finish(nil)
+	pipes.ReleaseFile("key", pipe, false)
+	if !pipes.IsRetired("key") {
+		t.Errorf("Delivered file pipe should be retired")
+	}
+	if _, err := pipes.ClaimFile("key", false); err != ErrKeyRetired {
+		t.Errorf("Retired key should not be claimed: %v", err)
+	}
+}
+
+func TestCollectionExpireFile(t *testing.T) {
+	pipes := MakePipeCollection()
+
+	pipe, _ := pipes.ClaimFile("key", true)
+	pipe.Transfer().OpenSpool("")
+	pipe.Transfer().Spool().Write([]byte("test input"))
+	pipes.ReleaseFile("key", pipe, true)
+
+	pipes.ExpireFiles(time.Now())
+	if !pipes.IsFilePipe("key") {
+		t.Errorf("File pipe expired too soon")
+	}
+
+	pipes.ExpireFiles(time.Now().Add(resumeTimeout + time.Minute))
+	if pipes.IsFilePipe("key") || !pipes.IsRetired("key") {
+		t.Errorf("Abandoned file pipe should be expired and retired")
+	}
+	select {
+	case <-pipe.Transfer().Aborted():
+	default:
+		t.Errorf("Expired transfer should be aborted")
+	}
+
+	// the key can be used again once it has been retired for long enough
+	pipes.ExpireFiles(time.Now().Add(resumeTimeout + retiredTimeout + time.Hour))
+	if pipes.IsRetired("key") || len(pipes.retired) > 0 {
+		t.Errorf("Retired key should be freed: %v", pipes.retired)
+	}
+}
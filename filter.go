@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"regexp"
+	"regexp/syntax"
+	"sync"
+)
+
+const (
+	// the longest pattern a client can ask for
+	maxPatternLength = 1024
+	// the most instructions a compiled pattern can have (repeats like a{1000} expand)
+	maxPatternSize = 10000
+	// partial lines longer than this are passed on without waiting for the rest
+	maxPendingLine = 64 * 1024
+)
+
+// compilePattern compiles a regular expression from a client
+// patterns that would be expensive to run are rejected
+func compilePattern(expr string) (*regexp.Regexp, error) {
+	if len(expr) > maxPatternLength {
+		return nil, fmt.Errorf("pattern longer than %d characters", maxPatternLength)
+	}
+	parsed, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+	prog, err := syntax.Compile(parsed.Simplify())
+	if err != nil {
+		return nil, err
+	}
+	if len(prog.Inst) > maxPatternSize {
+		return nil, fmt.Errorf("pattern is too complex")
+	}
+	return regexp.Compile(expr)
+}
+
+// filterLines keeps the lines of a buffer that pass a test
+func filterLines(buffer []byte, keep func(line []byte) bool) []byte {
+	var filtered []byte
+	for len(buffer) > 0 {
+		end := bytes.IndexByte(buffer, '\n') + 1
+		if end == 0 {
+			end = len(buffer)
+		}
+		if line := buffer[:end]; keep(line) {
+			filtered = append(filtered, line...)
+		}
+		buffer = buffer[end:]
+	}
+	return filtered
+}
+
+// LineFilter picks out the lines a receiver asked for (?grep=, ?grep-v= and ?from=)
+// data is split into whole lines first so a line split across two writes is matched as one
+type LineFilter struct {
+	grep  *regexp.Regexp // lines must match (nil for all)
+	grepV *regexp.Regexp // lines must not match (nil for none)
+	from  string         // only messages from this user ("" for everyone)
+
+	mu sync.Mutex
+	// the partial last line of each sender by id
+	pending map[int][]byte
+}
+
+// Filter returns the messages to pass on to the receiver
+// a system message from a sender flushes any partial line it left behind
+func (f *LineFilter) Filter(m Message) []Message {
+	if len(f.from) > 0 && m.fromUser != f.from {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	pending := f.pending[m.fromID]
+	if m.system {
+		delete(f.pending, m.fromID)
+		if len(pending) == 0 {
+			return []Message{m}
+		}
+		flushed := m
+		flushed.system = false
+		flushed.buffer = f.keep(pending)
+		if len(flushed.buffer) == 0 {
+			return []Message{m}
+		}
+		return []Message{flushed, m}
+	}
+
+	data := append(pending, m.buffer...)
+	end := bytes.LastIndexByte(data, '\n') + 1
+	if len(data)-end > maxPendingLine {
+		end = len(data)
+	}
+	if end < len(data) {
+		f.pending[m.fromID] = append([]byte(nil), data[end:]...)
+	} else {
+		delete(f.pending, m.fromID)
+	}
+	m.buffer = f.keep(data[:end])
+	if len(m.buffer) == 0 {
+		return nil
+	}
+	return []Message{m}
+}
+
+// keep the lines that match the patterns
+func (f *LineFilter) keep(data []byte) []byte {
+	if f.grep == nil && f.grepV == nil {
+		return data
+	}
+	return filterLines(data, func(line []byte) bool {
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		return (f.grep == nil || f.grep.Match(line)) && (f.grepV == nil || !f.grepV.Match(line))
+	})
+}
+
+// parseLineFilter reads the filter a receiver asked for
+// returns nil if the receiver wants everything
+func parseLineFilter(query url.Values) (*LineFilter, error) {
+	f := &LineFilter{
+		from:    query.Get("from"),
+		pending: make(map[int][]byte),
+	}
+	var err error
+	if expr := query.Get("grep"); len(expr) > 0 {
+		if f.grep, err = compilePattern(expr); err != nil {
+			return nil, fmt.Errorf("Invalid grep: %s", err)
+		}
+	}
+	if expr := query.Get("grep-v"); len(expr) > 0 {
+		if f.grepV, err = compilePattern(expr); err != nil {
+			return nil, fmt.Errorf("Invalid grep-v: %s", err)
+		}
+	}
+	if f.grep == nil && f.grepV == nil && len(f.from) == 0 {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// FilteredFormatter passes only the lines a receiver asked for on to its formatter
+type FilteredFormatter struct {
+	Formatter
+	filter *LineFilter
+}
+
+// Heartbeat uses the heartbeat of the wrapped formatter
+func (f FilteredFormatter) Heartbeat(data []byte) []byte {
+	return heartbeatFor(f.Formatter, data)
+}
+
+// Format the messages that pass the filter
+func (f FilteredFormatter) Format(m Message, receiver RecieveWriter) []byte {
+	var formatted []byte
+	for _, message := range f.filter.Filter(m) {
+		formatted = append(formatted, f.Formatter.Format(message, receiver)...)
+	}
+	return formatted
+}
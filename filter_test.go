@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestFilterLines(t *testing.T) {
+	filter := regexp.MustCompile("ERROR")
+	tests := map[string]string{
+		"ok\nERROR one\nok\n": "ERROR one\n",
+		"ERROR no newline":    "ERROR no newline",
+		"ok\nok\n":            "",
+	}
+	for input, expected := range tests {
+		if actual := string(filterLines([]byte(input), filter.Match)); actual != expected {
+			t.Errorf("Invalid filter of %q: %q %q", input, expected, actual)
+		}
+	}
+}
+
+func TestCompilePattern(t *testing.T) {
+	if _, err := compilePattern("ERROR|WARN"); err != nil {
+		t.Errorf("Error compiling pattern: %s", err.Error())
+	}
+	invalid := []string{
+		"(unclosed",
+		strings.Repeat("a", maxPatternLength+1),
+		"((a{1000}){1000}){1000}",
+		"(a{1000}){100}",
+	}
+	for _, expr := range invalid {
+		if _, err := compilePattern(expr); err == nil {
+			t.Errorf("Expected an error for %.20s", expr)
+		}
+	}
+}
+
+func TestLineFilter(t *testing.T) {
+	query, _ := url.ParseQuery("grep=ERROR&grep-v=ignore")
+	filter, err := parseLineFilter(query)
+	if err != nil {
+		t.Fatalf("Error parsing filter: %s", err.Error())
+	}
+
+	output := ""
+	write := func(m Message) {
+		for _, filtered := range filter.Filter(m) {
+			output += string(filtered.buffer)
+		}
+	}
+	write(Message{fromID: 1, buffer: []byte("ok\nERROR one\nERR")})
+	write(Message{fromID: 2, buffer: []byte("ERROR other sender\n")})
+	write(Message{fromID: 1, buffer: []byte("OR two\nERROR ignore\nERROR thr")})
+	write(Message{fromID: 1, buffer: []byte("disconnected\n"), system: true})
+
+	expected := "ERROR one\nERROR other sender\nERROR two\nERROR thrdisconnected\n"
+	if output != expected {
+		t.Errorf("Invalid filtered output: %q %q", expected, output)
+	}
+
+	if filter, _ := parseLineFilter(url.Values{}); filter != nil {
+		t.Errorf("Expected no filter")
+	}
+	if _, err := parseLineFilter(url.Values{"grep": {"("}}); err == nil {
+		t.Errorf("Expected an invalid grep")
+	}
+}
+
+func TestLineFilterFrom(t *testing.T) {
+	filter, _ := parseLineFilter(url.Values{"from": {"alice"}})
+	if messages := filter.Filter(Message{fromUser: "bob", buffer: []byte("hi\n")}); len(messages) != 0 {
+		t.Errorf("Expected bob to be filtered: %v", messages)
+	}
+	if messages := filter.Filter(Message{fromUser: "alice", buffer: []byte("hi\n")}); len(messages) != 1 {
+		t.Errorf("Expected alice to be passed on: %v", messages)
+	}
+}
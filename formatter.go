@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"unicode/utf8"
+)
+
+// Formatter renders a message for a particular receiver
+// an empty result means that nothing is written to the receiver
+type Formatter interface {
+	Format(m Message, receiver RecieveWriter) []byte
+	ContentType() string
+}
+
+// registered formatters by name (see ?format=<name>)
+var formatters = map[string]Formatter{
+	"raw":         RawFormatter{},
+	"interactive": InteractiveFormatter{},
+	"jsonl":       JSONLFormatter{},
+	"sse":         SSEFormatter{},
+	"hexdump":     HexdumpFormatter{},
+	"colour":      ColourFormatter{},
+	"asciicast":   AsciicastFormatter{},
+}
+
+// RegisterFormatter makes a formatter available to receivers by name
+func RegisterFormatter(name string, f Formatter) {
+	formatters[name] = f
+}
+
+// FindFormatter looks up a registered formatter by name
+func FindFormatter(name string) (Formatter, bool) {
+	f, exists := formatters[name]
+	return f, exists
+}
+
+// FormatterNames returns the names of all registered formatters in order
+func FormatterNames() []string {
+	names := make([]string, 0, len(formatters))
+	for name := range formatters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RawFormatter passes data through untouched
+// system messages and messages from the receiver itself are dropped
+type RawFormatter struct{}
+
+// Format the message for a non-interactive receiver
+func (RawFormatter) Format(m Message, receiver RecieveWriter) []byte {
+	// Don't send system messages
+	if m.system {
+		return []byte{}
+	}
+	// Don't echo messages back to the sender
+	if m.fromID == receiver.ID() {
+		return []byte{}
+	}
+	return m.buffer
+}
+
+// Heartbeat is the data chosen for plain streams
+func (RawFormatter) Heartbeat(data []byte) []byte {
+	return data
+}
+
+// ContentType of the formatted stream
+func (RawFormatter) ContentType() string {
+	return "text/plain; charset=utf-8"
+}
+
+// InteractiveFormatter prefixes messages with the username of the sender
+// system messages (connected/disconnected) are sent to everyone
+type InteractiveFormatter struct{}
+
+// Format the message for an interactive receiver
+func (InteractiveFormatter) Format(m Message, receiver RecieveWriter) []byte {
+	// Echo back system messages only
+	if m.fromID == receiver.ID() && !m.system {
+		return []byte{}
+	}
+	// Add username
+	prefix := keyPrefix(m)
+	if len(m.fromUser) > 0 {
+		prefix += senderName(m) + ": "
+	}
+	return append([]byte(prefix), m.buffer...)
+}
+
+// keyPrefix says which pipe a message came from if it wasn't the receiver's own
+func keyPrefix(m Message) string {
+	if !m.forwarded {
+		return ""
+	}
+	return "[" + m.key + "] "
+}
+
+// senderName returns the username of the sender marked if it owns a broadcast pipe
+func senderName(m Message) string {
+	if m.owner {
+		return m.fromUser + " (owner)"
+	}
+	return m.fromUser
+}
+
+// Heartbeat is the data chosen for plain streams
+func (InteractiveFormatter) Heartbeat(data []byte) []byte {
+	return data
+}
+
+// ContentType of the formatted stream
+func (InteractiveFormatter) ContentType() string {
+	return "text/plain; charset=utf-8"
+}
+
+// JSONLFormatter writes one json object per message
+type JSONLFormatter struct{}
+
+type jsonMessage struct {
+	ID     int    `json:"id"`
+	Key    string `json:"key,omitempty"`
+	User   string `json:"user"`
+	System bool   `json:"system"`
+	Data   string `json:"data"`
+	// "base64" if the data isn't valid utf-8 (json would replace the invalid bytes)
+	Encoding string `json:"encoding,omitempty"`
+	Size     string `json:"size,omitempty"`
+	Owner    bool   `json:"owner,omitempty"`
+}
+
+func makeJSONMessage(m Message) jsonMessage {
+	message := jsonMessage{
+		ID:     m.fromID,
+		Key:    m.key,
+		User:   m.fromUser,
+		System: m.system,
+		Data:   string(m.buffer),
+		Owner:  m.owner,
+	}
+	// binary data (or text split in the middle of a character) is passed on unchanged
+	if !utf8.Valid(m.buffer) {
+		message.Data = base64.StdEncoding.EncodeToString(m.buffer)
+		message.Encoding = "base64"
+	}
+	if m.size != nil {
+		message.Size = m.size.String()
+	}
+	return message
+}
+
+// Format the message as a single line of json
+func (JSONLFormatter) Format(m Message, receiver RecieveWriter) []byte {
+	if m.fromID == receiver.ID() && !m.system {
+		return []byte{}
+	}
+	line, err := json.Marshal(makeJSONMessage(m))
+	if err != nil {
+		return []byte{}
+	}
+	return append(line, '\n')
+}
+
+// ContentType of the formatted stream
+func (JSONLFormatter) ContentType() string {
+	return "application/x-ndjson"
+}
+
+// SSEFormatter writes messages as server-sent events
+type SSEFormatter struct{}
+
+// Format the message as a single event with a json payload
+func (SSEFormatter) Format(m Message, receiver RecieveWriter) []byte {
+	if m.fromID == receiver.ID() && !m.system {
+		return []byte{}
+	}
+	data, err := json.Marshal(makeJSONMessage(m))
+	if err != nil {
+		return []byte{}
+	}
+	event := "message"
+	if m.system {
+		event = "system"
+	}
+	return []byte(fmt.Sprintf("event: %s\ndata: %s\n\n", event, data))
+}
+
+// Heartbeat is a comment line which event source clients ignore
+func (SSEFormatter) Heartbeat(data []byte) []byte {
+	return []byte(": heartbeat\n\n")
+}
+
+// ContentType of the formatted stream
+func (SSEFormatter) ContentType() string {
+	return "text/event-stream"
+}
+
+// HexdumpFormatter writes data in the format of `hexdump -C`
+type HexdumpFormatter struct{}
+
+// Format the message as a hex dump
+func (HexdumpFormatter) Format(m Message, receiver RecieveWriter) []byte {
+	if m.system || m.fromID == receiver.ID() || len(m.buffer) < 1 {
+		return []byte{}
+	}
+	return []byte(hex.Dump(m.buffer))
+}
+
+// ContentType of the formatted stream
+func (HexdumpFormatter) ContentType() string {
+	return "text/plain; charset=utf-8"
+}
+
+// ColourFormatter is an interactive formatter with ansi coloured usernames
+type ColourFormatter struct{}
+
+// the foreground colours used for usernames (red, green, yellow, blue, magenta, cyan)
+var colourCodes = []int{31, 32, 33, 34, 35, 36}
+
+// Format the message for an interactive terminal
+func (ColourFormatter) Format(m Message, receiver RecieveWriter) []byte {
+	if m.fromID == receiver.ID() && !m.system {
+		return []byte{}
+	}
+	var buf bytes.Buffer
+	if m.system {
+		// dim system messages
+		buf.WriteString("\x1b[2m")
+		buf.WriteString(keyPrefix(m))
+		if len(m.fromUser) > 0 {
+			buf.WriteString(m.fromUser + ": ")
+		}
+		buf.Write(m.buffer)
+		buf.WriteString("\x1b[0m")
+		return buf.Bytes()
+	}
+	buf.WriteString(keyPrefix(m))
+	if len(m.fromUser) > 0 {
+		colour := colourCodes[m.fromID%len(colourCodes)]
+		buf.WriteString(fmt.Sprintf("\x1b[%dm%s\x1b[0m: ", colour, senderName(m)))
+	}
+	buf.Write(m.buffer)
+	return buf.Bytes()
+}
+
+// Heartbeat is the data chosen for plain streams
+func (ColourFormatter) Heartbeat(data []byte) []byte {
+	return data
+}
+
+// ContentType of the formatted stream
+func (ColourFormatter) ContentType() string {
+	return "text/plain; charset=utf-8"
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"testing"
+)
+
+type TestFormatReceiver struct {
+	TestReceiver
+	id int
+}
+
+func (r *TestFormatReceiver) ID() int {
+	return r.id
+}
+
+func TestFormatters(t *testing.T) {
+	receiver := &TestFormatReceiver{id: 1}
+	system := Message{fromID: 2, fromUser: "bob", buffer: []byte("connected\n"), system: true}
+	self := Message{fromID: 1, fromUser: "alice", buffer: []byte("hi\n")}
+	foreign := Message{fromID: 2, fromUser: "bob", buffer: []byte("hi\n")}
+	forwarded := Message{fromID: 2, fromUser: "bob", buffer: []byte("hi\n"), key: "team/linux", forwarded: true}
+	owner := Message{fromID: 2, fromUser: "bob", buffer: []byte("hi\n"), owner: true}
+	binary := Message{fromID: 2, fromUser: "bob", buffer: []byte{0xff, 0x00, 'h', 'i'}}
+
+	tests := []struct {
+		format   string
+		message  Message
+		expected string
+	}{
+		{"raw", system, ""},
+		{"raw", self, ""},
+		{"raw", foreign, "hi\n"},
+
+		{"interactive", system, "bob: connected\n"},
+		{"interactive", self, ""},
+		{"interactive", foreign, "bob: hi\n"},
+		{"interactive", forwarded, "[team/linux] bob: hi\n"},
+		{"interactive", owner, "bob (owner): hi\n"},
+
+		{"jsonl", system, `{"id":2,"user":"bob","system":true,"data":"connected\n"}` + "\n"},
+		{"jsonl", self, ""},
+		{"jsonl", foreign, `{"id":2,"user":"bob","system":false,"data":"hi\n"}` + "\n"},
+		{"jsonl", forwarded, `{"id":2,"key":"team/linux","user":"bob","system":false,"data":"hi\n"}` + "\n"},
+		{"jsonl", owner, `{"id":2,"user":"bob","system":false,"data":"hi\n","owner":true}` + "\n"},
+		{"jsonl", binary, `{"id":2,"user":"bob","system":false,"data":"/wBoaQ==","encoding":"base64"}` + "\n"},
+
+		{"sse", system, "event: system\ndata: " + `{"id":2,"user":"bob","system":true,"data":"connected\n"}` + "\n\n"},
+		{"sse", self, ""},
+		{"sse", foreign, "event: message\ndata: " + `{"id":2,"user":"bob","system":false,"data":"hi\n"}` + "\n\n"},
+		{"sse", binary, "event: message\ndata: " + `{"id":2,"user":"bob","system":false,"data":"/wBoaQ==","encoding":"base64"}` + "\n\n"},
+
+		{"hexdump", system, ""},
+		{"hexdump", self, ""},
+		{"hexdump", foreign, "00000000  68 69 0a                                          |hi.|\n"},
+
+		{"colour", system, "\x1b[2mbob: connected\n\x1b[0m"},
+		{"colour", self, ""},
+		{"colour", foreign, "\x1b[33mbob\x1b[0m: hi\n"},
+		{"colour", forwarded, "[team/linux] \x1b[33mbob\x1b[0m: hi\n"},
+		{"colour", owner, "\x1b[33mbob (owner)\x1b[0m: hi\n"},
+	}
+
+	for _, test := range tests {
+		formatter, exists := FindFormatter(test.format)
+		if !exists {
+			t.Fatalf("Formatter not registered: %s", test.format)
+		}
+		actual := string(formatter.Format(test.message, receiver))
+		if actual != test.expected {
+			t.Errorf("Invalid %s format: %q %q", test.format, test.expected, actual)
+		}
+	}
+}
+
+func TestFormatterNames(t *testing.T) {
+	names := FormatterNames()
+	if len(names) != 7 {
+		t.Errorf("Invalid formatter count: %d %d", 7, len(names))
+	}
+	for i := 1; i < len(names); i++ {
+		if names[i-1] > names[i] {
+			t.Errorf("Formatter names not sorted: %v", names)
+		}
+	}
+}
+
+type upperFormatter struct{}
+
+func (upperFormatter) Format(m Message, receiver RecieveWriter) []byte {
+	return []byte("UPPER")
+}
+
+func (upperFormatter) ContentType() string {
+	return "text/plain"
+}
+
+func TestRegisterFormatter(t *testing.T) {
+	RegisterFormatter("upper", upperFormatter{})
+	defer delete(formatters, "upper")
+
+	formatter, exists := FindFormatter("upper")
+	if !exists {
+		t.Fatalf("Registered formatter not found")
+	}
+	m := Message{fromID: 2, buffer: []byte("hi")}
+	if string(formatter.Format(m, &TestFormatReceiver{id: 1})) != "UPPER" {
+		t.Errorf("Registered formatter not used")
+	}
+}
@@ -1,3 +1,3 @@
 module github.com/jpschroeder/pipe-to-me
 
-go 1.12
+go 1.21
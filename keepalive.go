@@ -0,0 +1,131 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// the shortest heartbeat interval a receiver can ask for
+	minHeartbeat = time.Second
+	// how long a write to a receiver can take before the receiver is treated as gone
+	defaultWriteTimeout = time.Minute
+)
+
+// HeartbeatFormatter is implemented by formatters with their own way to keep a quiet stream alive
+// data is the byte sequence the receiver or the operator chose for plain streams
+type HeartbeatFormatter interface {
+	Heartbeat(data []byte) []byte
+}
+
+// heartbeatFor returns what is written to a receiver using the formatter to keep it alive
+// formatters without a heartbeat get none (extra bytes would corrupt a json or hexdump stream)
+func heartbeatFor(f Formatter, data []byte) []byte {
+	if h, ok := f.(HeartbeatFormatter); ok {
+		return h.Heartbeat(data)
+	}
+	return nil
+}
+
+// parseHeartbeatData reads a byte sequence from the command line with go escapes (e.g. \n or \x00)
+func parseHeartbeatData(s string) []byte {
+	if unquoted, err := strconv.Unquote(`"` + s + `"`); err == nil {
+		return []byte(unquoted)
+	}
+	return []byte(s)
+}
+
+// activity tracks when a receiver was last written to
+type activity struct {
+	mu sync.Mutex
+	// the last message and the last write of any kind (including heartbeats)
+	message, written time.Time
+}
+
+func (a *activity) wrote(heartbeat bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.written = time.Now()
+	if !heartbeat {
+		a.message = a.written
+	}
+}
+
+func (a *activity) times() (message, written time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.message, a.written
+}
+
+func makeActivity() *activity {
+	now := time.Now()
+	return &activity{message: now, written: now}
+}
+
+// KeepAlive sends heartbeats to a receiver while its pipe is quiet
+// and gives up on the receiver once it goes without messages for its idle timeout
+type KeepAlive struct {
+	idle chan bool // closed when the idle timeout is reached
+	stop chan bool
+	done chan bool
+}
+
+// Idle is closed once the receiver has gone without messages for its idle timeout
+func (k *KeepAlive) Idle() <-chan bool {
+	return k.idle
+}
+
+// Stop sending heartbeats - no more are written once Stop returns
+func (k *KeepAlive) Stop() {
+	close(k.stop)
+	<-k.done
+}
+
+func (k *KeepAlive) run(receiver Receiver, heartbeat []byte, interval, timeout time.Duration) {
+	defer close(k.done)
+	for {
+		now := time.Now()
+		message, written := receiver.activity.times()
+		wait := time.Duration(-1)
+		if timeout > 0 {
+			quiet := now.Sub(message)
+			if quiet >= timeout {
+				close(k.idle)
+				return
+			}
+			wait = timeout - quiet
+		}
+		if interval > 0 && len(heartbeat) > 0 {
+			if now.Sub(written) >= interval {
+				receiver.Heartbeat(heartbeat)
+				written = now
+			}
+			if next := interval - now.Sub(written); wait < 0 || next < wait {
+				wait = next
+			}
+		}
+		if wait < 0 {
+			// nothing to do until the receiver leaves
+			<-k.stop
+			return
+		}
+		select {
+		case <-k.stop:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// StartKeepAlive watches a receiver - a zero interval or empty heartbeat sends no heartbeats
+// and a zero timeout keeps the receiver connected however quiet the pipe is
+func StartKeepAlive(receiver Receiver, heartbeat []byte, interval, timeout time.Duration) *KeepAlive {
+	k := &KeepAlive{
+		idle: make(chan bool),
+		stop: make(chan bool),
+		done: make(chan bool),
+	}
+	go k.run(receiver, heartbeat, interval, timeout)
+	return k
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHeartbeatFor(t *testing.T) {
+	data := []byte("\n")
+	tests := []struct {
+		formatter Formatter
+		expected  string
+	}{
+		{RawFormatter{}, "\n"},
+		{ColourFormatter{}, "\n"},
+		{JSONLFormatter{}, ""},
+		{HexdumpFormatter{}, ""},
+		{SSEFormatter{}, ": heartbeat\n\n"},
+		{AsciicastFormatter{}, ""},
+		{FilteredFormatter{Formatter: SSEFormatter{}}, ": heartbeat\n\n"},
+	}
+	for _, test := range tests {
+		if actual := string(heartbeatFor(test.formatter, data)); actual != test.expected {
+			t.Errorf("Invalid heartbeat for %T: %q %q", test.formatter, test.expected, actual)
+		}
+	}
+}
+
+func TestParseHeartbeatData(t *testing.T) {
+	tests := map[string]string{
+		`\n`:   "\n",
+		`\x00`: "\x00",
+		"ping": "ping",
+		`"`:    `"`,
+	}
+	for flag, expected := range tests {
+		if actual := string(parseHeartbeatData(flag)); actual != expected {
+			t.Errorf("Invalid heartbeat data for %q: %q", flag, actual)
+		}
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	receiver := MakeReceiver(recorder, recorder, 1, RawFormatter{}, "")
+
+	// heartbeats keep coming but don't count as data for the idle timeout
+	start := time.Now()
+	keepAlive := StartKeepAlive(receiver, []byte("."), 10*time.Millisecond, 80*time.Millisecond)
+	defer keepAlive.Stop()
+	select {
+	case <-keepAlive.Idle():
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the receiver to go idle")
+	}
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("Receiver went idle too soon: %s", elapsed)
+	}
+	if beats := strings.Count(recorder.Body.String(), "."); beats < 3 {
+		t.Errorf("Expected several heartbeats: %q", recorder.Body.String())
+	}
+}
+
+type failingWriter struct {
+	writes *int
+}
+
+func (f failingWriter) Write(p []byte) (int, error) {
+	*f.writes++
+	return 0, errors.New("connection reset")
+}
+
+func TestReceiverFailure(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	writer := failingWriter{writes: new(int)}
+	gone := MakeReceiver(writer, recorder, 1, RawFormatter{}, "")
+	connected := &TestReceiver{}
+	pipe := MakePipe(&TestHandler{})
+	pipe.AddReceiver(gone)
+	pipe.AddReceiver(connected)
+
+	pipe.Write(Message{fromID: 2, buffer: []byte("hello\n")})
+	select {
+	case <-gone.Failed():
+	default:
+		t.Errorf("Expected the failed write to be noticed")
+	}
+	// the failed receiver is skipped until its handler removes it from the pipe
+	pipe.Write(Message{fromID: 2, buffer: []byte("again\n")})
+	if *writer.writes != 1 || connected.writer.String() != "hello\nagain\n" {
+		t.Errorf("Expected only the failed receiver to be skipped: %d %q", *writer.writes, connected.writer.String())
+	}
+	pipe.RemoveReceiver(gone)
+	if pipe.ReceiverCount() != 1 {
+		t.Errorf("Invalid receiver count: %d", pipe.ReceiverCount())
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/rand"
+	_ "embed"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// the words used by the words key style (256 short, easy to spell words)
+//
+//go:embed words.txt
+var wordList string
+
+var words = strings.Fields(wordList)
+
+// KeyStyle generates the random keys for new pipes
+type KeyStyle interface {
+	Generate() string
+	// Entropy is the number of random bits in each key
+	Entropy() float64
+	Description() string
+}
+
+// registered key styles by name (see /new?style=<name>)
+var keyStyles = map[string]KeyStyle{
+	"random": RandomKeyStyle{Length: keySize},
+	"words":  WordKeyStyle{Words: 3, Digits: 2},
+}
+
+// FindKeyStyle looks up a registered key style by name
+func FindKeyStyle(name string) (KeyStyle, bool) {
+	style, exists := keyStyles[name]
+	return style, exists
+}
+
+// KeyStyleNames returns the names of all registered key styles in order
+func KeyStyleNames() []string {
+	names := make([]string, 0, len(keyStyles))
+	for name := range keyStyles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// describe each key style and its entropy for the home page
+func keyStyleSummary() string {
+	var summary []string
+	for _, name := range KeyStyleNames() {
+		style := keyStyles[name]
+		summary = append(summary, fmt.Sprintf("%s: %s (%.0f bits)", name, style.Description(), style.Entropy()))
+	}
+	return strings.Join(summary, ", ")
+}
+
+// RandomKeyStyle generates keys of random letters and digits like aZ3k9QxP
+type RandomKeyStyle struct {
+	Length int
+}
+
+// Generate a new key
+func (k RandomKeyStyle) Generate() string {
+	return string(randKey(k.Length))
+}
+
+// Entropy of each key in bits
+func (k RandomKeyStyle) Entropy() float64 {
+	return float64(k.Length) * math.Log2(float64(len(keyBytes)))
+}
+
+// Description of the keys
+func (k RandomKeyStyle) Description() string {
+	return fmt.Sprintf("%d letters and digits", k.Length)
+}
+
+// WordKeyStyle generates keys that are easy to read aloud like brave-otter-lamp-92
+type WordKeyStyle struct {
+	Words  int
+	Digits int
+}
+
+// Generate a new key
+func (k WordKeyStyle) Generate() string {
+	parts := make([]string, 0, k.Words+1)
+	for i := 0; i < k.Words; i++ {
+		parts = append(parts, words[randInt(len(words))])
+	}
+	if k.Digits > 0 {
+		parts = append(parts, fmt.Sprintf("%0*d", k.Digits, randInt(int(math.Pow10(k.Digits)))))
+	}
+	return strings.Join(parts, "-")
+}
+
+// Entropy of each key in bits
+func (k WordKeyStyle) Entropy() float64 {
+	return float64(k.Words)*math.Log2(float64(len(words))) + float64(k.Digits)*math.Log2(10)
+}
+
+// Description of the keys
+func (k WordKeyStyle) Description() string {
+	return fmt.Sprintf("%d words and %d digits", k.Words, k.Digits)
+}
+
+// randInt returns a uniformly random number in [0, n)
+func randInt(n int) int {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		panic(err)
+	}
+	return int(i.Int64())
+}
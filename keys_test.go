@@ -0,0 +1,44 @@
+package main
+
+import (
+	"math"
+	"regexp"
+	"testing"
+)
+
+func TestWordList(t *testing.T) {
+	if len(words) != 256 {
+		t.Errorf("Invalid word list size: %d", len(words))
+	}
+	seen := map[string]bool{}
+	for _, word := range words {
+		if !keyRegex.MatchString("/"+word) || seen[word] {
+			t.Errorf("Invalid word: %s", word)
+		}
+		seen[word] = true
+	}
+}
+
+func TestKeyStyles(t *testing.T) {
+	tests := []struct {
+		style   KeyStyle
+		pattern string
+		entropy float64
+	}{
+		{RandomKeyStyle{Length: 8}, "^[a-zA-Z0-9]{8}$", 47.6},
+		{WordKeyStyle{Words: 3, Digits: 2}, "^[a-z]+-[a-z]+-[a-z]+-[0-9]{2}$", 30.6},
+		{WordKeyStyle{Words: 2}, "^[a-z]+-[a-z]+$", 16},
+	}
+	for _, test := range tests {
+		key := test.style.Generate()
+		if !regexp.MustCompile(test.pattern).MatchString(key) || !keyRegex.MatchString("/"+key) {
+			t.Errorf("Invalid key: %s", key)
+		}
+		if key == test.style.Generate() && test.entropy > 20 {
+			t.Errorf("Duplicate key generated: %s", key)
+		}
+		if entropy := test.style.Entropy(); math.Abs(entropy-test.entropy) > 0.1 {
+			t.Errorf("Invalid entropy for %s: %f %f", test.style.Description(), test.entropy, entropy)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits restricts how much data can be sent (zero values are unlimited)
+type Limits struct {
+	SessionBytes   int64         // total bytes in a single upload
+	PipeBytes      int64         // total bytes through a pipe across all uploads
+	BytesPerSecond int64         // upload rate of a single upload
+	IdleTimeout    time.Duration // time an upload can go without sending data
+}
+
+// Lower returns the limits reduced to any lower requested values
+// requested values can't raise a limit set by the operator
+func (l Limits) Lower(requested Limits) Limits {
+	lower := func(current, requested int64) int64 {
+		if requested > 0 && (current == 0 || requested < current) {
+			return requested
+		}
+		return current
+	}
+	return Limits{
+		SessionBytes:   lower(l.SessionBytes, requested.SessionBytes),
+		PipeBytes:      lower(l.PipeBytes, requested.PipeBytes),
+		BytesPerSecond: lower(l.BytesPerSecond, requested.BytesPerSecond),
+		IdleTimeout:    time.Duration(lower(int64(l.IdleTimeout), int64(requested.IdleTimeout))),
+	}
+}
+
+func (l Limits) String() string {
+	var limits []string
+	if l.SessionBytes > 0 {
+		limits = append(limits, fmt.Sprintf("%d MB per upload", l.SessionBytes/(1024*1024)))
+	}
+	if l.PipeBytes > 0 {
+		limits = append(limits, fmt.Sprintf("%d MB per pipe", l.PipeBytes/(1024*1024)))
+	}
+	if l.BytesPerSecond > 0 {
+		limits = append(limits, fmt.Sprintf("%d KB/s", l.BytesPerSecond/1024))
+	}
+	if l.IdleTimeout > 0 {
+		limits = append(limits, fmt.Sprintf("%s idle", l.IdleTimeout))
+	}
+	if len(limits) == 0 {
+		return "unlimited"
+	}
+	return strings.Join(limits, ", ")
+}
+
+// LimitError is returned when an upload is stopped by one of the limits
+type LimitError struct {
+	reason string
+	status int // the http status code to report to the sender
+}
+
+func (e *LimitError) Error() string {
+	return e.reason
+}
+
+// Status returns the http status code for the limit
+func (e *LimitError) Status() int {
+	return e.status
+}
+
+// SessionReader enforces the limits on a single upload
+type SessionReader struct {
+	reader io.Reader
+	limits Limits
+	pipe   *Pipe
+	read   int64
+	start  time.Time
+	idle   *time.Timer
+	// closed if the upload goes idle
+	idled    chan bool
+	idleOnce sync.Once
+
+	mu  sync.Mutex
+	err error
+}
+
+// Read from the upload - fails once a limit is exceeded and throttles to the upload rate
+func (s *SessionReader) Read(p []byte) (int, error) {
+	if err := s.Err(); err != nil {
+		return 0, err
+	}
+
+	// read at most one byte past a size limit to find out if it was exceeded
+	remaining := int64(-1)
+	reason := ""
+	if s.limits.SessionBytes > 0 {
+		remaining = s.limits.SessionBytes - s.read
+		reason = fmt.Sprintf("upload limit of %d bytes reached", s.limits.SessionBytes)
+	}
+	if s.limits.PipeBytes > 0 {
+		pipeRemaining := s.limits.PipeBytes - int64(s.pipe.BytesSent())
+		if pipeRemaining < 0 {
+			pipeRemaining = 0
+		}
+		if remaining < 0 || pipeRemaining < remaining {
+			remaining = pipeRemaining
+			reason = fmt.Sprintf("pipe limit of %d bytes reached", s.limits.PipeBytes)
+		}
+	}
+	if remaining >= 0 && int64(len(p)) > remaining+1 {
+		p = p[:remaining+1]
+	}
+
+	n, err := s.reader.Read(p)
+	// the upload went idle while waiting for this read
+	if limitErr := s.Err(); limitErr != nil {
+		return 0, limitErr
+	}
+	if s.idle != nil {
+		s.idle.Reset(s.limits.IdleTimeout)
+	}
+	if remaining >= 0 && int64(n) > remaining {
+		n = int(remaining)
+		err = s.fail(reason, http.StatusRequestEntityTooLarge)
+	}
+	s.read += int64(n)
+
+	// sleep until the upload is back down to the allowed rate
+	if s.limits.BytesPerSecond > 0 {
+		allowed := time.Duration(s.read * int64(time.Second) / s.limits.BytesPerSecond)
+		if wait := allowed - time.Since(s.start); wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+	return n, err
+}
+
+// record the first limit that was hit
+func (s *SessionReader) fail(reason string, status int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = &LimitError{reason: reason, status: status}
+	}
+	return s.err
+}
+
+// Err returns the limit that stopped the upload or nil
+func (s *SessionReader) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Idle returns a notification channel that is closed if the upload goes idle
+func (s *SessionReader) Idle() <-chan bool {
+	return s.idled
+}
+
+// Stop releases the idle timer once the upload is finished
+func (s *SessionReader) Stop() {
+	if s.idle != nil {
+		s.idle.Stop()
+	}
+}
+
+// MakeSessionReader wraps an upload with the limits of the pipe
+func MakeSessionReader(r io.Reader, limits Limits, pipe *Pipe) *SessionReader {
+	s := &SessionReader{
+		reader: r,
+		limits: limits,
+		pipe:   pipe,
+		start:  time.Now(),
+		idled:  make(chan bool),
+	}
+	if limits.IdleTimeout > 0 {
+		s.idle = time.AfterFunc(limits.IdleTimeout, func() {
+			s.fail(fmt.Sprintf("idle timeout of %s reached", limits.IdleTimeout), http.StatusRequestTimeout)
+			s.idleOnce.Do(func() { close(s.idled) })
+		})
+	}
+	return s
+}
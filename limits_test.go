@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimitsLower(t *testing.T) {
+	operator := Limits{SessionBytes: 100, PipeBytes: 0, BytesPerSecond: 10, IdleTimeout: time.Minute}
+	requested := Limits{SessionBytes: 200, PipeBytes: 50, BytesPerSecond: 5, IdleTimeout: 0}
+
+	expected := Limits{SessionBytes: 100, PipeBytes: 50, BytesPerSecond: 5, IdleTimeout: time.Minute}
+	if actual := operator.Lower(requested); actual != expected {
+		t.Errorf("Invalid lowered limits: %v %v", expected, actual)
+	}
+}
+
+func TestLimitsString(t *testing.T) {
+	if (Limits{}).String() != "unlimited" {
+		t.Errorf("Invalid unlimited string: %s", Limits{}.String())
+	}
+	l := Limits{SessionBytes: 64 * 1024 * 1024, BytesPerSecond: 10 * 1024, IdleTimeout: 5 * time.Minute}
+	if l.String() != "64 MB per upload, 10 KB/s, 5m0s idle" {
+		t.Errorf("Invalid limits string: %s", l.String())
+	}
+}
+
+func TestSessionBytesLimit(t *testing.T) {
+	pipe := MakePipe(&TestHandler{})
+	session := MakeSessionReader(strings.NewReader("test input"), Limits{SessionBytes: 4}, pipe)
+
+	output, err := io.ReadAll(session)
+	if string(output) != "test" {
+		t.Errorf("Invalid limited output: %s %s", "test", string(output))
+	}
+	if _, ok := err.(*LimitError); !ok {
+		t.Errorf("Session limit not reported: %v", err)
+	}
+
+	// exactly at the limit is allowed
+	session = MakeSessionReader(strings.NewReader("test"), Limits{SessionBytes: 4}, pipe)
+	if _, err := io.ReadAll(session); err != nil {
+		t.Errorf("Upload at the limit should succeed: %s", err.Error())
+	}
+}
+
+func TestPipeBytesLimit(t *testing.T) {
+	pipe := MakePipe(&TestHandler{})
+	pipe.AddBytes(8)
+	session := MakeSessionReader(strings.NewReader("test input"), Limits{PipeBytes: 10}, pipe)
+
+	output, err := io.ReadAll(session)
+	if string(output) != "te" {
+		t.Errorf("Invalid limited output: %s %s", "te", string(output))
+	}
+	if err == nil || !strings.Contains(err.Error(), "pipe limit") {
+		t.Errorf("Pipe limit not reported: %v", err)
+	}
+}
+
+func TestSessionRateLimit(t *testing.T) {
+	pipe := MakePipe(&TestHandler{})
+	session := MakeSessionReader(strings.NewReader("0123456789"), Limits{BytesPerSecond: 100}, pipe)
+
+	start := time.Now()
+	io.ReadAll(session)
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("Upload not throttled: %s", elapsed)
+	}
+}
+
+func TestSessionIdleTimeout(t *testing.T) {
+	pipe := MakePipe(&TestHandler{})
+	session := MakeSessionReader(strings.NewReader(""), Limits{IdleTimeout: 10 * time.Millisecond}, pipe)
+	defer session.Stop()
+
+	select {
+	case <-session.Idle():
+	case <-time.After(time.Second):
+		t.Fatalf("Idle timeout not reached")
+	}
+	if err, ok := session.Err().(*LimitError); !ok || err.Status() != 408 {
+		t.Errorf("Idle timeout not reported: %v", session.Err())
+	}
+}
@@ -6,36 +6,17 @@ type Message struct {
 	fromUser string
 	buffer   []byte
 	system   bool
+	// the key of the pipe the message was written to
+	key string
+	// the message was written to another pipe (e.g. one matched by a wildcard subscription)
+	forwarded bool
+	// the new size of a shared terminal (nil unless this is a resize event from a tty sender)
+	size *TerminalSize
+	// the message was sent by the owner of a broadcast pipe
+	owner bool
 }
 
-// Format customizes the message for a particular receiver
+// Format customizes the message for a particular receiver (see Formatter)
 func (m Message) Format(receiver RecieveWriter) []byte {
-	if receiver.Interactive() {
-		return m.formatInteractive(receiver)
-	}
-	return m.formatNonInteractive(receiver)
-}
-
-func (m Message) formatInteractive(receiver RecieveWriter) []byte {
-	// Echo back system messages only
-	if m.fromID == receiver.ID() && !m.system {
-		return []byte{}
-	}
-	// Add username
-	if len(m.fromUser) > 0 {
-		m.buffer = append([]byte(m.fromUser+": "), m.buffer...)
-	}
-	return m.buffer
-}
-
-func (m Message) formatNonInteractive(receiver RecieveWriter) []byte {
-	// Don't send system messages
-	if m.system {
-		return []byte{}
-	}
-	// Don't echo messages back to the sender
-	if m.fromID == receiver.ID() {
-		return []byte{}
-	}
-	return m.buffer
+	return receiver.Formatter().Format(m, receiver)
 }
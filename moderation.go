@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	// ErrKicked is returned when a user the owner removed tries to rejoin
+	ErrKicked = errors.New("removed from the pipe by its owner")
+	// ErrLocked is returned when someone new tries to join a locked pipe
+	ErrLocked = errors.New("pipe is locked")
+	// ErrInvalidAction is returned for a moderation command that doesn't exist or is missing its user
+	ErrInvalidAction = errors.New("invalid moderation action (kick <user>, mute <user>, unmute <user>, lock, unlock)")
+)
+
+// the actions the owner of a pipe can take - the owner can type them into an interactive session
+var actionRegex = regexp.MustCompile(`^/(kick|mute|unmute|lock|unlock)(?: +(\S.*?))?\s*$`)
+
+// ModerationAction is a single command from the owner of a pipe
+type ModerationAction struct {
+	Action string
+	User   string // the user acted on ("" for lock and unlock)
+}
+
+// validate that the user is given for the actions that need one (and only for them)
+func (a ModerationAction) validate() error {
+	switch a.Action {
+	case "kick", "mute", "unmute":
+		if len(a.User) > 0 {
+			return nil
+		}
+	case "lock", "unlock":
+		if len(a.User) == 0 {
+			return nil
+		}
+	}
+	return ErrInvalidAction
+}
+
+// the announcement sent to everyone in the room
+func (a ModerationAction) String() string {
+	switch a.Action {
+	case "kick":
+		return fmt.Sprintf("kicked %s", a.User)
+	case "mute":
+		return fmt.Sprintf("muted %s", a.User)
+	case "unmute":
+		return fmt.Sprintf("unmuted %s", a.User)
+	case "lock":
+		return "locked the pipe"
+	}
+	return "unlocked the pipe"
+}
+
+// parseAction reads a command typed by the owner (/kick bob)
+func parseAction(buffer []byte) (ModerationAction, bool) {
+	m := actionRegex.FindSubmatch(buffer)
+	if m == nil {
+		return ModerationAction{}, false
+	}
+	action := ModerationAction{Action: string(m[1]), User: string(m[2])}
+	return action, action.validate() == nil
+}
+
+// parseActionQuery reads a command sent to /<key>/moderate (?kick=bob, ?lock)
+func parseActionQuery(query url.Values) (ModerationAction, error) {
+	for _, name := range []string{"kick", "mute", "unmute", "lock", "unlock"} {
+		if query.Has(name) {
+			action := ModerationAction{Action: name, User: strings.TrimSpace(query.Get(name))}
+			return action, action.validate()
+		}
+	}
+	return ModerationAction{}, ErrInvalidAction
+}
+
+// Moderation holds the restrictions the owner has placed on a pipe
+// users are identified by username so they stay removed when they reconnect
+type Moderation struct {
+	kicked map[string]bool
+	muted  map[string]bool
+	locked bool
+}
+
+// apply an action to the restrictions
+func (m *Moderation) apply(action ModerationAction) {
+	switch action.Action {
+	case "kick":
+		m.kicked[action.User] = true
+		delete(m.muted, action.User)
+	case "mute":
+		m.muted[action.User] = true
+	case "unmute":
+		delete(m.muted, action.User)
+	case "lock":
+		m.locked = true
+	case "unlock":
+		m.locked = false
+	}
+}
+
+// MakeModeration creates the restrictions for a pipe with no one removed
+func MakeModeration() Moderation {
+	return Moderation{
+		kicked: make(map[string]bool),
+		muted:  make(map[string]bool),
+	}
+}
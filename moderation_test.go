@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+type TestNamedReceiver struct {
+	TestReceiver
+	id       int
+	username string
+}
+
+func (r *TestNamedReceiver) ID() int {
+	return r.id
+}
+
+func (r *TestNamedReceiver) Username() string {
+	return r.username
+}
+
+func (r *TestNamedReceiver) Formatter() Formatter {
+	return InteractiveFormatter{}
+}
+
+func TestParseAction(t *testing.T) {
+	tests := []struct {
+		command string
+		action  ModerationAction
+		valid   bool
+	}{
+		{"/kick bob\n", ModerationAction{Action: "kick", User: "bob"}, true},
+		{"/mute client 3\n", ModerationAction{Action: "mute", User: "client 3"}, true},
+		{"/unmute bob", ModerationAction{Action: "unmute", User: "bob"}, true},
+		{"/lock\n", ModerationAction{Action: "lock"}, true},
+		{"/unlock\n", ModerationAction{Action: "unlock"}, true},
+		{"/kick\n", ModerationAction{}, false},
+		{"/lock bob\n", ModerationAction{}, false},
+		{"/ban bob\n", ModerationAction{}, false},
+		{"please /kick bob\n", ModerationAction{}, false},
+	}
+	for _, test := range tests {
+		action, valid := parseAction([]byte(test.command))
+		if valid != test.valid || (valid && action != test.action) {
+			t.Errorf("Invalid action for %q: %v %v", test.command, action, valid)
+		}
+	}
+
+	if action, err := parseActionQuery(url.Values{"kick": {"bob"}}); err != nil || action.String() != "kicked bob" {
+		t.Errorf("Invalid query action: %v %v", action, err)
+	}
+	if action, err := parseActionQuery(url.Values{"lock": {""}}); err != nil || action.String() != "locked the pipe" {
+		t.Errorf("Invalid query action: %v %v", action, err)
+	}
+	if _, err := parseActionQuery(url.Values{"mute": {""}}); err != ErrInvalidAction {
+		t.Errorf("Expected a missing user to be invalid: %v", err)
+	}
+	if _, err := parseActionQuery(url.Values{}); err != ErrInvalidAction {
+		t.Errorf("Expected no action to be invalid: %v", err)
+	}
+}
+
+func TestModeratePipe(t *testing.T) {
+	pipe := MakePipe(&TestHandler{})
+	pipe.SetOwner("token", false)
+	alice := &TestNamedReceiver{id: 1, username: "alice"}
+	bob := &TestNamedReceiver{id: 2, username: "bob"}
+	pipe.AddReceiver(alice)
+	pipe.AddReceiver(bob)
+
+	// muted users get a notice instead of sending
+	pipe.Moderate(ModerationAction{Action: "mute", User: "bob"}, 1, "alice")
+	MakeSender(pipe, 2, "bob").Write([]byte("spam\n"))
+	if alice.writer.String() != "alice: connected\nbob: connected\nalice: muted bob\n" {
+		t.Errorf("Invalid room after mute: %q", alice.writer.String())
+	}
+	if bob.writer.String() != "bob: connected\nalice: muted bob\nbob: muted by the owner, your message was not sent\n" {
+		t.Errorf("Invalid notice for muted user: %q", bob.writer.String())
+	}
+
+	// kicked users are disconnected and can't rejoin
+	pipe.Moderate(ModerationAction{Action: "kick", User: "bob"}, 1, "alice")
+	if bob.closeCount != 1 || alice.closeCount != 0 {
+		t.Errorf("Expected only bob to be disconnected: %d %d", bob.closeCount, alice.closeCount)
+	}
+	if err := pipe.Admit("bob", ""); err != ErrKicked {
+		t.Errorf("Expected bob to be kept out: %v", err)
+	}
+
+	pipe.Moderate(ModerationAction{Action: "lock"}, 1, "alice")
+	if err := pipe.Admit("carol", ""); err != ErrLocked {
+		t.Errorf("Expected the pipe to be locked: %v", err)
+	}
+	if err := pipe.Admit("bob", "token"); err != nil {
+		t.Errorf("Expected the owner to be let in: %v", err)
+	}
+	pipe.Moderate(ModerationAction{Action: "unlock"}, 1, "alice")
+	if err := pipe.Admit("carol", ""); err != nil {
+		t.Errorf("Expected the pipe to be unlocked: %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// content types offered by the pages of the site
+const (
+	textType = "text/plain"
+	htmlType = "text/html"
+	jsonType = "application/json"
+)
+
+// negotiate picks the offered content type that best matches the Accept header
+// the first offer is used when there is no Accept header, for */* and for ties
+func negotiate(r *http.Request, offers ...string) string {
+	accept := r.Header.Get("Accept")
+	if len(accept) == 0 {
+		return offers[0]
+	}
+	best, bestQ := offers[0], 0.0
+	for _, offer := range offers {
+		if q := acceptQuality(accept, offer); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// acceptQuality returns the q value of the most specific Accept entry that matches the content type
+func acceptQuality(accept, contentType string) float64 {
+	major := strings.SplitN(contentType, "/", 2)[0] + "/*"
+	q, specificity := 0.0, 0
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(fields[0]))
+		match := 0
+		switch mediaType {
+		case contentType:
+			match = 3
+		case major:
+			match = 2
+		case "*/*":
+			match = 1
+		}
+		if match <= specificity {
+			continue
+		}
+		specificity = match
+		q = 1
+		for _, field := range fields[1:] {
+			field = strings.TrimSpace(field)
+			if strings.HasPrefix(field, "q=") {
+				if value, err := strconv.ParseFloat(strings.TrimPrefix(field, "q="), 64); err == nil {
+					q = value
+				}
+			}
+		}
+	}
+	return q
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := map[string]string{
+		"":    textType,
+		"*/*": textType,
+		"text/html,application/xhtml+xml,*/*;q=0.8": htmlType,
+		"application/json":                          jsonType,
+		"text/*;q=0.5, application/json":            jsonType,
+		"text/html;q=0.5, text/plain;q=0.9":         textType,
+		"application/json;q=0, */*":                 textType,
+		"image/png":                                 textType,
+	}
+	for accept, expected := range tests {
+		r, _ := http.NewRequest("GET", "/", nil)
+		if len(accept) > 0 {
+			r.Header.Set("Accept", accept)
+		}
+		if actual := negotiate(r, textType, htmlType, jsonType); actual != expected {
+			t.Errorf("Invalid negotiation for %q: %s %s", accept, expected, actual)
+		}
+	}
+}
@@ -1,38 +1,109 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
 	"regexp"
-	"text/template"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
 const (
-	maxUploadMb = 64
-	keySize     = 8
+	keySize = 8
+	// the most data a reserved pipe can keep for new receivers
+	maxReplay = 1024 * 1024
+	// the size of the tokens given to the owners of broadcast pipes
+	tokenSize = 24
+	// how long a sender waits for a receiver when neither it nor the operator says otherwise
+	defaultBlockWait = 24 * time.Hour
+	// the status logged for a sender that left before a receiver connected (as used by nginx)
+	statusClientClosed = 499
+	// how often abandoned file transfers and old recordings are cleaned up
+	expireInterval = 10 * time.Minute
 )
 
+// ErrNoReceiver is returned when no receiver connects within the time a sender is willing to wait
+var ErrNoReceiver = errors.New("no receiver connected")
+
+// ErrNoSender is returned when no sender connects to a file pipe within the time a receiver is willing to wait
+var ErrNoSender = errors.New("no sender connected")
+
 // Handlers
 
 type server struct {
 	allPipes  PipeCollection
 	baseURL   string
-	maxID     int
-	templates *template.Template
+	maxID     atomic.Int64
+	templates *Templates
+	spoolDir  string   // where file transfers are buffered so that they can be resumed
+	name      string   // the name of this instance shown in the templates
+	contact   string   // how to reach the operator shown in the templates
+	modes     []string // the modes that are enabled (nil for all)
+	keyStyle  string   // the style of generated keys when /new?style= isn't set ("" for random)
+	// keys that only the holder of a secret can create
+	reservations *ReservationStore
+	// matches rpc mode requests with responders
+	rpc        *RPCBroker
+	rpcTimeout time.Duration // how long a request waits to be read and replied to (0 for the default)
+	blockWait  time.Duration // how long block and file mode connections wait for the other side (0 for the default)
+	// where pipes are recorded with ?record=1 ("" if recording is disabled)
+	recordDir   string
+	recordBytes int64         // the maximum size of a recording (0 for unlimited)
+	retention   time.Duration // how long a recording is kept after its last change
+	// keeping receivers on quiet pipes alive and dropping the ones that are gone
+	heartbeat     time.Duration // how often quiet receivers get a heartbeat (0 unless they ask)
+	heartbeatData []byte        // the heartbeat for plain streams (none if empty)
+	recvTimeout   time.Duration // how long a receiver can go without data (0 for unlimited)
+	writeTimeout  time.Duration // how long a write to a receiver can take (0 for the default)
 }
 
-var keyRegex = regexp.MustCompile("^/([a-zA-Z0-9]+)$")
+// replies to rpc mode requests are posted to /<key>/reply/<id>
+var replyRegex = regexp.MustCompile("^(/.+)/reply/([a-zA-Z0-9]+)$")
+
+// keys are one or more segments separated by slashes (team/builds/linux)
+// receivers can use * for any one segment and ** for any trailing segments
+var keyRegex = regexp.MustCompile(`^/((?:[a-zA-Z0-9-]+|\*\*?)(?:/(?:[a-zA-Z0-9-]+|\*\*?))*)$`)
 
 type params struct {
 	key         string
-	id          int    // unique id for this request
-	failure     bool   // failure mode will not allow a connection if there is no one on the other end
-	block       bool   // block mode will not receive data until there is a connection on the other end
-	interactive bool   // interactive mode will send notifications down the pipe on connect/disconnect
-	username    string // username passed via basic auth or "" if empty
+	id          int         // unique id for this request
+	failure     bool        // failure mode will not allow a connection if there is no one on the other end
+	block       bool        // block mode will not receive data until there is a connection on the other end
+	interactive bool        // interactive mode will send notifications down the pipe on connect/disconnect
+	username    string      // username passed via basic auth or "" if empty
+	format      string      // name of the formatter used to render messages for the receiver
+	file        bool        // file mode allows a single sender and receiver and passes on the content type, length and filename
+	rpc         bool        // rpc mode delivers each request to one receiver and streams its reply back
+	tty         bool        // tty mode shares a terminal session including its size
+	broadcast   bool        // broadcast mode only lets the creator of the pipe send to it
+	moderate    bool        // the creator of the pipe can kick and mute users and lock the pipe
+	token       string      // the token of the owner of a pipe passed via X-Owner-Token or ?token=
+	owner       bool        // this connection created the owned pipe and is given its token
+	wantsJSON   bool        // the Accept header prefers json (receivers of a tty pipe get asciicast)
+	filename    string      // filename passed via ?filename= or "" if empty
+	receipt     bool        // receipt mode will end the sender's response with a delivery summary
+	msg         bool        // message mode sends the body as a single message without closing the receivers
+	users       bool        // list the users connected to the pipe instead of receiving
+	secret      string      // the secret of a reserved key passed via the basic auth password or ?secret=
+	reserve     bool        // claim, update, describe or release a reserved key instead of connecting
+	filter      *LineFilter // the lines the receiver wants (nil for everything)
+	record      bool        // record everything written to the pipe so that it can be replayed
+	limits      Limits      // limits requested by the creator of the pipe (can only lower the defaults)
+
+	// keeping a receiver on a quiet pipe alive (see keepAliveSettings)
+	heartbeat     time.Duration // how often the receiver wants a heartbeat when the pipe is quiet (0 for the server default)
+	heartbeatData []byte        // the heartbeat the receiver wants for plain streams (nil for the server default)
+	timeout       time.Duration // how long the receiver can go without data before it is disconnected (0 for the server default)
+	wait          time.Duration // how long the sender waits for a receiver to connect (0 for the server default)
 }
 
 // the root http handler
@@ -44,156 +115,1420 @@ func (s *server) handler(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path == "/favicon.ico" {
 		return
 	}
+	if strings.HasPrefix(r.URL.Path, "/static/") {
+		staticHandler().ServeHTTP(w, r)
+		return
+	}
 	if r.URL.Path == "/robots.txt" {
 		fmt.Fprintf(w, "User-agent: *\nDisallow: /")
 		return
 	}
 	if r.URL.Path == "/new" {
-		fmt.Fprintf(w, "%s%s", s.baseURL, randKey(keySize))
+		s.newKey(w, r)
 		return
 	}
 	if r.Method == "OPTIONS" {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
+		return
+	}
+	// a key can't end in /bridge (but bridge on its own is fine)
+	if strings.HasSuffix(r.URL.Path, "/bridge") && r.URL.Path != "/bridge" {
+		s.bridge(w, r)
+		return
+	}
+	if strings.HasSuffix(r.URL.Path, "/moderate") && r.URL.Path != "/moderate" {
+		s.moderate(w, r)
+		return
+	}
+	if strings.HasSuffix(r.URL.Path, "/replay") && r.URL.Path != "/replay" {
+		s.replay(w, r)
+		return
+	}
+	if m := replyRegex.FindStringSubmatch(r.URL.Path); m != nil && keyRegex.MatchString(m[1]) {
+		s.rpcReply(w, r, m[1][1:], m[2])
 		return
 	}
 
-	params := parseParams(r)
+	params, err := parseParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if params == nil {
 		http.NotFound(w, r)
 		return
 	}
-	s.maxID++
-	params.id = s.maxID
+	if _, exists := FindFormatter(params.format); !exists {
+		http.Error(w, "Invalid format", http.StatusBadRequest)
+		return
+	}
+	// compile the filter once for the whole connection
+	filter, err := parseLineFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	params.filter = filter
+	// browsers opening the pipe url get the web client instead of the raw stream
+	if wantsWebClient(r) {
+		s.webClient(w, r)
+		return
+	}
+	if r.Method == "GET" && params.users {
+		s.users(w, r, params)
+		return
+	}
+	if isPattern(params.key) {
+		if !validPattern(params.key) {
+			http.Error(w, "** can only be used at the end of a key", http.StatusBadRequest)
+			return
+		}
+		if r.Method != "GET" || params.file || params.reserve || params.rpc {
+			http.Error(w, "Wildcard keys can only be received from", http.StatusBadRequest)
+			return
+		}
+	}
+	if params.reserve {
+		s.reserve(w, r, params)
+		return
+	}
+	// only the owner of a reserved key can create its pipe, anyone can join once it exists
+	if reservation, reserved := s.reservations.Find(params.key); reserved {
+		if s.allPipes.FindPipe(params.key) == nil && !reservation.Allows(params.secret) {
+			http.Error(w, "Pipe is reserved", http.StatusForbidden)
+			return
+		}
+		params.applyDefaults(reservation.Defaults, len(r.URL.Query().Get("format")) > 0)
+	}
+	site := s.site()
+	for _, mode := range params.modes() {
+		if !site.ModeEnabled(mode) {
+			http.Error(w, fmt.Sprintf("The %s mode is not enabled", mode), http.StatusForbidden)
+			return
+		}
+	}
+	if params.record && len(s.recordDir) == 0 {
+		http.Error(w, "Recording is not enabled", http.StatusForbidden)
+		return
+	}
+	params.id = int(s.maxID.Add(1))
+
+	if s.allPipes.IsRetired(params.key) {
+		http.Error(w, "Pipe has been retired", http.StatusGone)
+		return
+	}
+	if !params.file && s.allPipes.IsFilePipe(params.key) {
+		http.Error(w, "Pipe already in use", http.StatusConflict)
+		return
+	}
+	// the owner of a pipe can keep out the users it kicked and lock it to newcomers
+	if pipe := s.allPipes.FindPipe(params.key); pipe != nil {
+		switch pipe.Admit(getUsername(params.username, params.id), params.token) {
+		case ErrKicked:
+			http.Error(w, "You were removed from this pipe", http.StatusForbidden)
+			return
+		case ErrLocked:
+			http.Error(w, "Pipe is locked", http.StatusLocked)
+			return
+		}
+	}
+
+	if params.rpc {
+		switch r.Method {
+		case "GET":
+			s.rpcListen(w, r, params)
+		case "POST", "PUT":
+			s.rpcCall(w, r, params)
+		default:
+			http.Error(w, "Invalid Method", http.StatusNotFound)
+		}
+		return
+	}
 
 	if r.Method == "GET" {
+		pipe := s.createPipe(params)
+		if params.file {
+			s.recvFile(w, r, params)
+			return
+		}
+		if pipe.Full() {
+			http.Error(w, "Pipe is full", http.StatusServiceUnavailable)
+			return
+		}
 		s.recv(w, r, params)
 		return
 	}
 	if r.Method == "POST" || r.Method == "PUT" {
+		if params.file {
+			s.createPipe(params)
+			s.sendFile(w, r, params)
+			return
+		}
+		if params.msg {
+			s.sendMessage(w, r, params)
+			return
+		}
+		// the pipe is created once the upload has been checked
 		s.send(w, r, params)
 		return
 	}
 	http.Error(w, "Invalid Method", http.StatusNotFound)
 }
 
-func parseParams(r *http.Request) *params {
+// returns nil if the path isn't a key and an error for a malformed parameter
+func parseParams(r *http.Request) (*params, error) {
 	// /<key>
 	m := keyRegex.FindStringSubmatch(r.URL.Path)
 	if m == nil {
-		return nil
+		return nil, nil
 	}
 	key := m[1]
 	query := r.URL.Query()
 	exists := func(p string) bool {
 		return len(query.Get(p)) > 0
 	}
-	username, _, _ := r.BasicAuth()
+	// a malformed duration is refused rather than replaced by the default
+	var err error
+	duration := func(p string) time.Duration {
+		d, parseErr := parseDuration(query.Get(p))
+		if parseErr != nil && err == nil {
+			err = fmt.Errorf("Invalid %s (use a positive duration like 30s)", p)
+		}
+		return d
+	}
+	// an empty ?heartbeatdata= turns off the server's heartbeat
+	var heartbeatData []byte
+	if query.Has("heartbeatdata") {
+		heartbeatData = []byte(query.Get("heartbeatdata"))
+	}
+	// senders have the idle limit for their uploads instead
+	var timeout time.Duration
+	if r.Method == "GET" {
+		timeout = duration("timeout")
+	}
+	limits, limitsErr := parseLimits(query)
+	if limitsErr != nil {
+		return nil, limitsErr
+	}
+	username, secret, _ := r.BasicAuth()
 	if len(username) == 0 {
 		username = query.Get("user")
 	}
-	return &params{
+	if len(secret) == 0 {
+		secret = query.Get("secret")
+	}
+	interactive := exists("i") || exists("interactive") || query.Get("mode") == "interactive"
+	tty := query.Get("mode") == "tty"
+	wantsJSON := negotiate(r, textType, jsonType, asciicastType) != textType
+	format := query.Get("format")
+	if len(format) == 0 {
+		format = "raw"
+		if interactive {
+			format = "interactive"
+		}
+		if tty && wantsJSON {
+			format = "asciicast"
+		}
+	}
+	p := &params{
 		key:         key,
 		failure:     exists("f") || exists("fail") || query.Get("mode") == "fail",
 		block:       exists("b") || exists("block") || query.Get("mode") == "block",
-		interactive: exists("i") || exists("interactive") || query.Get("mode") == "interactive",
+		interactive: interactive,
 		username:    username,
+		format:      format,
+		file:        query.Get("mode") == "file",
+		rpc:         query.Get("mode") == "rpc",
+		tty:         tty,
+		broadcast:   query.Get("mode") == "broadcast",
+		moderate:    exists("moderate"),
+		token:       ownerToken(r),
+		wantsJSON:   wantsJSON,
+		record:      exists("record"),
+		filename:    query.Get("filename"),
+		receipt:     exists("receipt"),
+		msg:         exists("msg"),
+		users:       query.Has("users"),
+		secret:      secret,
+		reserve:     query.Has("reserve"),
+		limits:      limits,
+
+		heartbeat:     duration("heartbeat"),
+		heartbeatData: heartbeatData,
+		timeout:       timeout,
+		wait:          duration("wait"),
+	}
+	if err != nil {
+		return nil, err
 	}
+	return p, nil
+}
+
+// parseDuration reads a positive duration - an empty value is 0 (the default)
+func parseDuration(value string) (time.Duration, error) {
+	if len(value) == 0 {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, errors.New("duration must be positive")
+	}
+	return d, nil
+}
+
+// ownerToken returns the token of the owner of a pipe passed via X-Owner-Token or ?token=
+func ownerToken(r *http.Request) string {
+	if token := r.Header.Get("X-Owner-Token"); len(token) > 0 {
+		return token
+	}
+	return r.URL.Query().Get("token")
+}
+
+// read the limits requested by the creator of a pipe
+// ?maxmb=<MB per upload>&pipemb=<MB per pipe>&rate=<KB/s>&idle=<duration>
+// a malformed limit is refused rather than ignored so the pipe isn't created without it
+func parseLimits(query url.Values) (Limits, error) {
+	var err error
+	number := func(p string) int64 {
+		if len(query.Get(p)) == 0 {
+			return 0
+		}
+		n, parseErr := strconv.ParseInt(query.Get(p), 10, 64)
+		if (parseErr != nil || n <= 0) && err == nil {
+			err = fmt.Errorf("Invalid %s (use a positive whole number)", p)
+		}
+		return n
+	}
+	limits := Limits{
+		SessionBytes:   number("maxmb") * 1024 * 1024,
+		PipeBytes:      number("pipemb") * 1024 * 1024,
+		BytesPerSecond: number("rate") * 1024,
+	}
+	idle, parseErr := parseDuration(query.Get("idle"))
+	if parseErr != nil && err == nil {
+		err = errors.New("Invalid idle (use a positive duration like 30s)")
+	}
+	limits.IdleTimeout = idle
+	if err != nil {
+		return Limits{}, err
+	}
+	return limits, nil
+}
+
+// create, list or remove the bridges of a pipe (/<key>/bridge?to=<other>)
+func (s *server) bridge(w http.ResponseWriter, r *http.Request) {
+	m := keyRegex.FindStringSubmatch(strings.TrimSuffix(r.URL.Path, "/bridge"))
+	if m == nil || isPattern(m[1]) {
+		http.NotFound(w, r)
+		return
+	}
+	key := m[1]
+	query := r.URL.Query()
+	to := query.Get("to")
+
+	if r.Method == "GET" {
+		for _, bridge := range s.allPipes.Bridges(key) {
+			fmt.Fprintf(w, "%s\n", bridge)
+		}
+		return
+	}
+	if !keyRegex.MatchString("/"+to) || isPattern(to) || to == key {
+		http.Error(w, "Invalid bridge destination (use ?to=<key>)", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case "POST", "PUT":
+		var filter *regexp.Regexp
+		if len(query.Get("filter")) > 0 {
+			var err error
+			if filter, err = compilePattern(query.Get("filter")); err != nil {
+				http.Error(w, "Invalid filter", http.StatusBadRequest)
+				return
+			}
+		}
+		if s.allPipes.IsFilePipe(key) || s.allPipes.IsFilePipe(to) {
+			http.Error(w, "Pipe already in use", http.StatusConflict)
+			return
+		}
+		bridges := []string{key, to}
+		// both directions unless ?oneway=1
+		if len(query.Get("oneway")) < 1 {
+			bridges = append(bridges, to, key)
+		}
+		// only the owner of a broadcast pipe can bridge into it
+		token := ownerToken(r)
+		for i := 1; i < len(bridges); i += 2 {
+			if pipe := s.allPipes.FindPipe(bridges[i]); pipe != nil && pipe.Broadcast() && !pipe.IsOwner(token) {
+				http.Error(w, "Only the owner can send to a broadcast pipe", http.StatusForbidden)
+				return
+			}
+		}
+		var created []*Bridge
+		for i := 0; i < len(bridges); i += 2 {
+			bridge, err := s.allPipes.AddBridge(bridges[i], bridges[i+1], filter)
+			if err != nil {
+				http.Error(w, "Both pipes need a connection to be bridged", http.StatusNotFound)
+				return
+			}
+			created = append(created, bridge)
+		}
+		w.WriteHeader(http.StatusCreated)
+		for _, bridge := range created {
+			fmt.Fprintf(w, "%s\n", bridge)
+		}
+	case "DELETE":
+		if s.allPipes.RemoveBridges(key, to) == 0 {
+			http.Error(w, fmt.Sprintf("No bridge between %s and %s", key, to), http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, "%s and %s unbridged\n", key, to)
+	default:
+		http.Error(w, "Invalid Method", http.StatusNotFound)
+	}
+}
+
+// kick, mute or unmute a user or lock or unlock a pipe (/<key>/moderate?kick=<user>)
+func (s *server) moderate(w http.ResponseWriter, r *http.Request) {
+	m := keyRegex.FindStringSubmatch(strings.TrimSuffix(r.URL.Path, "/moderate"))
+	if m == nil || isPattern(m[1]) {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != "POST" && r.Method != "PUT" {
+		http.Error(w, "Invalid Method", http.StatusNotFound)
+		return
+	}
+	pipe := s.allPipes.FindPipe(m[1])
+	if pipe == nil {
+		http.Error(w, "No connections to moderate", http.StatusNotFound)
+		return
+	}
+	if !pipe.IsOwner(ownerToken(r)) {
+		http.Error(w, "Only the owner can moderate a pipe", http.StatusForbidden)
+		return
+	}
+	action, err := parseActionQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	username, _, _ := r.BasicAuth()
+	if len(username) == 0 {
+		username = "owner"
+	}
+	pipe.Moderate(action, -1, username)
+	fmt.Fprintf(w, "%s\n", action)
+}
+
+// give the connection that created an owned pipe its token
+func writeOwnerToken(w http.ResponseWriter, p *params) {
+	if p.owner {
+		w.Header().Set("X-Owner-Token", p.token)
+		w.Header().Set("Access-Control-Expose-Headers", "X-Owner-Token")
+	}
+}
+
+// create the pipe with the limits asked for by the first connection and the defaults of a reserved key
+func (s *server) createPipe(p *params) *Pipe {
+	pipe, created := s.allPipes.CreatePipe(p.key, p.limits)
+	// whoever creates a broadcast or moderated pipe owns it (with their own token or a new one)
+	if (p.broadcast || p.moderate) && created {
+		if len(p.token) == 0 {
+			p.token = string(randKey(tokenSize))
+		}
+		pipe.SetOwner(p.token, p.broadcast)
+		p.owner = true
+	}
+	if reservation, reserved := s.reservations.Find(p.key); reserved {
+		pipe.SetDefaults(reservation.Defaults)
+	}
+	// once a pipe is being recorded it is recorded until it is deleted
+	if p.record {
+		started, err := pipe.StartRecording(func() (*Recorder, error) {
+			return OpenRecorder(s.recordDir, p.key, s.recordBytes)
+		})
+		if err != nil {
+			log.Println("Error opening recording:", err)
+		}
+		// everyone already on the pipe is told that what they send is being kept from now on
+		if started {
+			pipe.Write(Message{
+				fromID:   p.id,
+				fromUser: p.username,
+				buffer:   []byte("recording started\n"),
+				system:   true})
+		}
+	}
+	return pipe
+}
+
+// play back the recording of a pipe with its original timing (/<key>/replay?speed=2)
+func (s *server) replay(w http.ResponseWriter, r *http.Request) {
+	m := keyRegex.FindStringSubmatch(strings.TrimSuffix(r.URL.Path, "/replay"))
+	if m == nil || isPattern(m[1]) || len(s.recordDir) == 0 || r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+	key := m[1]
+	query := r.URL.Query()
+	speed := 1.0
+	if len(query.Get("speed")) > 0 {
+		var err error
+		if speed, err = strconv.ParseFloat(query.Get("speed"), 64); err != nil || speed <= 0 {
+			http.Error(w, "Invalid speed", http.StatusBadRequest)
+			return
+		}
+	}
+	format := query.Get("format")
+	if len(format) == 0 {
+		format = "raw"
+	}
+	formatter, exists := FindFormatter(format)
+	if !exists {
+		http.Error(w, "Invalid format", http.StatusBadRequest)
+		return
+	}
+
+	file, err := os.Open(recordingPath(s.recordDir, key))
+	if err != nil {
+		http.Error(w, "No recording", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	// an asciicast carries its own timing so it is written all at once for a player
+	if _, cast := formatter.(AsciicastFormatter); cast {
+		w.Header().Set("Content-Type", formatter.ContentType())
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		if err := WriteAsciicast(w, file, key, speed); err != nil {
+			log.Println("Error converting recording:", err)
+		}
+		return
+	}
+
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Type", formatter.ContentType())
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	flusher, _ := w.(http.Flusher)
+	// the viewer isn't part of the recording so it gets everyone's messages
+	viewer := MakeReceiver(w, flusher, -1, formatter, "")
+	PlayRecording(r.Context(), file, speed, func(m RecordedMessage) {
+		viewer.Write(m.Message(key).Format(viewer))
+	})
+}
+
+// claim, update, describe or release a reserved key
+func (s *server) reserve(w http.ResponseWriter, r *http.Request, p *params) {
+	var reservation Reservation
+	var err error
+	status := http.StatusOK
+	switch r.Method {
+	case "GET":
+		var reserved bool
+		if reservation, reserved = s.reservations.Find(p.key); !reserved {
+			err = ErrNotReserved
+		}
+	case "POST", "PUT":
+		var defaults PipeDefaults
+		var created bool
+		if defaults, err = s.parseDefaults(r.URL.Query()); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		reservation, created, err = s.reservations.Reserve(p.key, p.secret, defaults)
+		if created {
+			status = http.StatusCreated
+		}
+	case "DELETE":
+		err = s.reservations.Release(p.key, p.secret)
+		reservation = Reservation{Key: p.key}
+	default:
+		http.Error(w, "Invalid Method", http.StatusNotFound)
+		return
+	}
+
+	switch err {
+	case nil:
+	case ErrSecretRequired:
+		http.Error(w, "A secret is required (curl -u :<secret>)", http.StatusUnauthorized)
+		return
+	case ErrKeyReserved:
+		http.Error(w, "Pipe is reserved", http.StatusForbidden)
+		return
+	case ErrNotReserved:
+		http.Error(w, "Pipe is not reserved", http.StatusNotFound)
+		return
+	default:
+		log.Println("Error saving reservations:", err)
+		http.Error(w, "Unable to save reservation", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(status)
+	if r.Method == "DELETE" {
+		fmt.Fprintf(w, "%s released\n", reservation.Key)
+		return
+	}
+	fmt.Fprintf(w, "%s\n", reservation)
+}
+
+// parseDefaults reads the defaults for a reserved key (?mode=&replay=&maxreceivers=)
+func (s *server) parseDefaults(query url.Values) (PipeDefaults, error) {
+	number := func(p string) int {
+		n, _ := strconv.Atoi(query.Get(p))
+		return n
+	}
+	defaults := PipeDefaults{
+		Mode:         query.Get("mode"),
+		Replay:       number("replay"),
+		MaxReceivers: number("maxreceivers"),
+	}
+	// a file mode pipe is retired after one transfer so it can't be a default
+	if len(defaults.Mode) > 0 && (defaults.Mode == "file" || !s.site().ModeEnabled(defaults.Mode)) {
+		return defaults, fmt.Errorf("The %s mode can't be a default", defaults.Mode)
+	}
+	if defaults.Replay > maxReplay {
+		return defaults, fmt.Errorf("The replay size can't be more than %d bytes", maxReplay)
+	}
+	return defaults, nil
+}
+
+// homeData is passed to the home templates
+type homeData struct {
+	Site
+	URL     string // a new randomly generated pipe
+	Key     string
+	Formats string
+	QR      string // the url as a terminal qr code if ?qr=1 was requested
+	// the style used to generate the key and the entropy of the key in bits
+	Style     string
+	Entropy   float64
+	KeyStyles string // a description of each of the available styles
+}
+
+// statsData is passed to the stats template
+type statsData struct {
+	Site
+	Global PipeStats
+	Active PipeStats
+}
+
+// sample data used to check templates for unknown fields when they are loaded
+var templateSamples = map[string]interface{}{
+	"home": homeData{
+		Site:      Site{Name: "name", Contact: "contact", Base: "base", Modes: allModes},
+		URL:       "url",
+		Key:       "key",
+		Formats:   "formats",
+		QR:        "qr",
+		Style:     "style",
+		KeyStyles: "styles",
+	},
+	"stats": statsData{
+		Site: Site{Name: "name", Contact: "contact", Base: "base", Modes: allModes},
+	},
+}
+
+// site returns the description of this instance for the templates
+func (s *server) site() Site {
+	modes := s.modes
+	if modes == nil {
+		modes = allModes
+	}
+	name := s.name
+	if len(name) == 0 {
+		name = "pipeto.me"
+	}
+	return Site{
+		Name:    name,
+		Contact: s.contact,
+		Base:    s.baseURL,
+		Limits:  s.allPipes.Limits(),
+		Modes:   modes,
+	}
+}
+
+// pipeLinks describes a new pipe to scripts
+type pipeLinks struct {
+	URL     string  `json:"url"`
+	Key     string  `json:"key"`
+	Send    string  `json:"send"`
+	Recv    string  `json:"recv"`
+	Style   string  `json:"style"`
+	Entropy float64 `json:"entropy"`
+}
+
+// keyStyleName returns the name of the style requested with ?style= or the server default
+func (s *server) keyStyleName(r *http.Request) string {
+	if style := r.URL.Query().Get("style"); len(style) > 0 {
+		return style
+	}
+	if len(s.keyStyle) > 0 {
+		return s.keyStyle
+	}
+	return "random"
+}
+
+func (s *server) homeData(r *http.Request) (homeData, error) {
+	name := s.keyStyleName(r)
+	style, exists := FindKeyStyle(name)
+	if !exists {
+		return homeData{}, fmt.Errorf("Invalid style (available styles: %s)", strings.Join(KeyStyleNames(), ", "))
+	}
+	key := style.Generate()
+	data := homeData{
+		Site:      s.site(),
+		URL:       fmt.Sprintf("%s%s", s.baseURL, key),
+		Key:       key,
+		Formats:   strings.Join(FormatterNames(), ", "),
+		Style:     name,
+		Entropy:   style.Entropy(),
+		KeyStyles: keyStyleSummary(),
+	}
+	if len(r.URL.Query().Get("qr")) > 0 {
+		if qr, err := EncodeQR([]byte(data.URL)); err == nil {
+			data.QR = qr.Terminal()
+		}
+	}
+	return data, nil
 }
 
 // handler that generates a new key and gives the user information on it
+// browsers get an html page, scripts can ask for json and curl gets the manual
 func (s *server) home(w http.ResponseWriter, r *http.Request) {
-	newkey := randKey(keySize)
-	data := struct {
-		URL         string
-		MaxUploadMb int
-	}{
-		URL:         fmt.Sprintf("%s%s", s.baseURL, newkey),
-		MaxUploadMb: maxUploadMb,
+	s.newPipe(w, r, func(data homeData) {
+		s.templates.Execute(w, "home", false, data)
+	})
+}
+
+// handler that generates a new key - curl just gets the url (and its qr code if asked for)
+func (s *server) newKey(w http.ResponseWriter, r *http.Request) {
+	s.newPipe(w, r, func(data homeData) {
+		fmt.Fprintf(w, "%s", data.URL)
+		if len(data.QR) > 0 {
+			fmt.Fprintf(w, "\n%s", data.QR)
+		}
+	})
+}
+
+// describe a new pipe in the format the client asked for
+func (s *server) newPipe(w http.ResponseWriter, r *http.Request, text func(homeData)) {
+	data, err := s.homeData(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Add("Vary", "Accept")
+	switch negotiate(r, textType, htmlType, jsonType) {
+	case jsonType:
+		w.Header().Set("Content-Type", jsonType)
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		json.NewEncoder(w).Encode(pipeLinks{
+			URL:     data.URL,
+			Key:     data.Key,
+			Send:    fmt.Sprintf("curl -T- %s", data.URL),
+			Recv:    fmt.Sprintf("curl %s", data.URL),
+			Style:   data.Style,
+			Entropy: data.Entropy,
+		})
+	case htmlType:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		s.templates.Execute(w, "home", true, data)
+	default:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		text(data)
 	}
-	s.templates.ExecuteTemplate(w, "home", data)
 }
 
 func (s *server) stats(w http.ResponseWriter, r *http.Request) {
-	data := struct {
-		Global PipeStats
-		Active PipeStats
-	}{
+	data := statsData{
+		Site:   s.site(),
 		Global: s.allPipes.GlobalStats(),
 		Active: s.allPipes.ActiveStats(),
 	}
-	s.templates.ExecuteTemplate(w, "stats", data)
+	s.templates.Execute(w, "stats", false, data)
 }
 
 // receive data from any senders
 func (s *server) recv(w http.ResponseWriter, r *http.Request, p *params) {
+	w, done := compressResponse(w, r)
+	defer done()
+	s.recvUntil(w, r, p, nil)
+}
+
+// keepAliveSettings returns the heartbeat interval and data and the idle timeout for a receiver
+// receivers can choose their own heartbeat (no more often than minHeartbeat) and lower the idle timeout
+func (s *server) keepAliveSettings(p *params) (time.Duration, []byte, time.Duration) {
+	interval := s.heartbeat
+	if p.heartbeat > 0 {
+		interval = p.heartbeat
+		if interval < minHeartbeat {
+			interval = minHeartbeat
+		}
+	}
+	data := s.heartbeatData
+	if p.heartbeatData != nil {
+		data = p.heartbeatData
+	}
+	timeout := s.recvTimeout
+	if p.timeout > 0 && (timeout == 0 || p.timeout < timeout) {
+		timeout = p.timeout
+	}
+	return interval, data, timeout
+}
+
+// receive data from any senders until the stream is closed or the uploaded channel is closed
+func (s *server) recvUntil(w http.ResponseWriter, r *http.Request, p *params, uploaded <-chan bool) {
+	formatter, _ := FindFormatter(p.format)
+	// an asciicast starts with the size of the terminal and times events from when the receiver connected
+	var header []byte
+	if _, cast := formatter.(AsciicastFormatter); cast {
+		start := time.Now()
+		formatter = AsciicastFormatter{start: start}
+		size := defaultTerminalSize
+		if pipe := s.allPipes.FindPipe(p.key); pipe != nil {
+			size = pipe.TerminalSize()
+		}
+		header = asciicastHeader(size, start, p.key, 0)
+	}
+	if p.filter != nil {
+		formatter = FilteredFormatter{Formatter: formatter, filter: p.filter}
+	}
+
 	// this is required so that data is streamed back to the client
 	w.Header().Set("X-Content-Type-Options", "nosniff")
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Type", formatter.ContentType())
 	w.Header().Set("Access-Control-Allow-Origin", "*")
+	writeOwnerToken(w, p)
 
-	// this is used to flush output back to the client as it is received
+	// in failure mode, don't allow a connection if there are no senders
+	if pipe := s.allPipes.FindPipe(p.key); p.failure && (pipe == nil || pipe.SenderCount() < 1) {
+		if pipe != nil {
+			s.allPipes.DeletePipeIfEmpty(p.key, pipe)
+		}
+		http.Error(w, "No senders connected", http.StatusInternalServerError)
+		return
+	}
+
+	// send the headers right away so that clients know they are connected before any data arrives
+	// senders write to the response (through the receiver) once it is added so this has to come first
 	flusher, _ := w.(http.Flusher)
+	if len(header) > 0 {
+		w.Write(header)
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
 
 	// store the active streams by key so that data can be sent by another request
-	receiver := MakeReceiver(w, flusher, p.id, p.interactive, p.username)
-	pipe := s.allPipes.AddReceiver(p.key, receiver)
+	receiver := MakeReceiver(w, flusher, p.id, formatter, p.username)
+	// a write that stalls (e.g. on a half-open connection) fails instead of holding up the pipe
+	receiver.controller = http.NewResponseController(w)
+	receiver.writeTimeout = s.writeTimeout
+	if receiver.writeTimeout <= 0 {
+		receiver.writeTimeout = defaultWriteTimeout
+	}
+	s.allPipes.AddReceiver(p.key, receiver)
 	defer s.allPipes.RemoveReceiver(p.key, receiver)
+	defer receiver.Stop()
 
-	// in failure mode, don't allow a connection if there are no senders
-	if p.failure && pipe.SenderCount() < 1 {
-		http.Error(w, "No senders connected", http.StatusInternalServerError)
-		return
-	}
+	interval, heartbeat, timeout := s.keepAliveSettings(p)
+	keepAlive := StartKeepAlive(receiver, heartbeatFor(formatter, heartbeat), interval, timeout)
+	defer keepAlive.Stop()
 
 	select {
 	// the receiver disconnected before completion
 	case <-r.Context().Done():
 	// a sender completed a transfer and closed the stream (EOF received)
 	case <-receiver.CloseNotify():
+	// this request's own upload completed (the stream may have closed before the receiver was added)
+	case <-uploaded:
+	// the receiver stopped accepting data (a half-open connection shows up as a write that times out)
+	case <-receiver.Failed():
+	// nothing was sent to the receiver within its idle timeout
+	case <-keepAlive.Idle():
 	}
 }
 
 // send data to any connected receivers
 func (s *server) send(w http.ResponseWriter, r *http.Request, p *params) {
+	// compressed uploads are decompressed and recompressed for each receiver that accepts it
+	// (unlike file mode they can't be passed through - a receiver joining part way through
+	// couldn't decode the stream and each receiver's messages are formatted for it)
+	body, err := decompressBody(r)
+	if err != nil {
+		http.Error(w, "Unsupported Content-Encoding", http.StatusUnsupportedMediaType)
+		return
+	}
+	// a tty sender can give the size of its terminal up front (resizes can also be sent in-band)
+	var size *TerminalSize
+	if header := r.Header.Get("X-Terminal-Size"); p.tty && len(header) > 0 {
+		parsed, err := parseTerminalSize(header)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		size = &parsed
+	}
+	s.createPipe(p)
+	// keep reading the upload after the response starts so that interactive clients can chat
+	// otherwise the server discards the rest of the request body on the first write back
+	http.NewResponseController(w).EnableFullDuplex()
+
 	// Look to see if there are any receivers attached to this key
-	pipe := s.allPipes.AddSender(p.key)
+	pipe, err := s.allPipes.AddSender(p.key, p.token)
+	if err != nil {
+		http.Error(w, "Only the owner can send to a broadcast pipe", http.StatusForbidden)
+		return
+	}
 	defer s.allPipes.RemoveSender(p.key, pipe)
 
 	// in failure mode, don't allow a connection if there are no recievers
-	if p.failure && pipe.ReceiverCount() < 1 {
+	if p.failure && pipe.ListenerCount() < 1 {
 		http.Error(w, "No receivers connected", http.StatusExpectationFailed)
 		return
 	}
 
 	// in block mode, wait for a receiver to connect
-	if p.block && pipe.ReceiverCount() < 1 {
-		receiverAdded := pipe.ReceiverAddedSubscribe()
-		defer pipe.ReceiverAddedUnSubscribe(receiverAdded)
+	if p.block {
+		wait := s.blockWaitFor(p)
+		if err := waitForReceiver(r, pipe, wait); err != nil {
+			receiverWaitError(w, err, wait)
+			return
+		}
+	}
+
+	// the client can send its body now - nothing has been written back yet
+	// so the 100 Continue always goes out before the response headers below
+	continueUpload(w, r)
+
+	w, done := compressResponse(w, r)
+	defer done()
+
+	// upload limits
+	session := MakeSessionReader(body, pipe.Limits(), pipe)
+	defer session.Stop()
+
+	// copy the request body to all senders
+	sender := MakeSender(pipe, p.id, p.username)
+	if p.tty {
+		sender = MakeTerminalSender(pipe, p.id, p.username)
+	}
+	sender.owner = pipe.IsOwner(p.token)
+	if size != nil {
+		sender.write(Message{buffer: size.Sequence(), size: size})
+	}
+	uploaded := make(chan bool)
+	var receipt Receipt
+	go func() {
+		var err error
+		receipt, err = sender.Copy(session)
+		if err == nil || session.Err() != nil {
+			close(uploaded)
+		}
+	}()
+
+	// stop receiving when the upload reaches EOF, hits a limit or goes idle
+	stopped := make(chan bool)
+	go func() {
 		select {
-		// the receiver disconnected before completion
+		case <-uploaded:
+		case <-session.Idle():
 		case <-r.Context().Done():
+		}
+		close(stopped)
+	}()
+
+	s.recvUntil(w, r, p, stopped)
+
+	// tell the sender and any interactive receivers why the upload was stopped
+	if err := session.Err(); err != nil {
+		fmt.Fprintf(w, "%s\n", err)
+		pipe.Write(Message{
+			fromID:   p.id,
+			fromUser: sender.Username(),
+			buffer:   []byte(err.Error() + "\n"),
+			system:   true})
+		return
+	}
+
+	// in receipt mode, end the response with a summary once the upload reaches EOF
+	if p.receipt {
+		select {
+		case <-r.Context().Done():
+		case <-uploaded:
+			receipt.Write(w, r)
+		}
+	}
+}
+
+// send the body as a single message without closing the receivers at EOF
+// the browser client sends each chat line this way while it receives on its own connection
+func (s *server) sendMessage(w http.ResponseWriter, r *http.Request, p *params) {
+	body, err := decompressBody(r)
+	if err != nil {
+		http.Error(w, "Unsupported Content-Encoding", http.StatusUnsupportedMediaType)
+		return
+	}
+	s.createPipe(p)
+	pipe, err := s.allPipes.AddSender(p.key, p.token)
+	if err != nil {
+		http.Error(w, "Only the owner can send to a broadcast pipe", http.StatusForbidden)
+		return
+	}
+	defer s.allPipes.RemoveSender(p.key, pipe)
+	// the pipe outlives this request while anyone is listening so its owner needs the token
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	writeOwnerToken(w, p)
+
+	if p.failure && pipe.ListenerCount() < 1 {
+		http.Error(w, "No receivers connected", http.StatusExpectationFailed)
+		return
+	}
+
+	session := MakeSessionReader(body, pipe.Limits(), pipe)
+	defer session.Stop()
+	sender := MakeSender(pipe, p.id, p.username)
+	sender.owner = pipe.IsOwner(p.token)
+	// unlike Sender.Copy the receivers aren't closed once the body is sent
+	if _, err := io.Copy(sender, session); err != nil {
+		if limitErr, ok := err.(*LimitError); ok {
+			http.Error(w, limitErr.Error(), limitErr.Status())
 			return
-		// allow a timeout if the receiver disconnected without closing the context
-		case <-time.After(24 * time.Hour):
+		}
+		http.Error(w, "Message interrupted", http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// wait for a single rpc request and pass its body on to this responder
+func (s *server) rpcListen(w http.ResponseWriter, r *http.Request, p *params) {
+	listener := s.rpc.Listen(p.key)
+	var call *RPCCall
+	select {
+	case call = <-listener:
+	case <-r.Context().Done():
+		// a request may have been handed over just as the responder left
+		if call = s.rpc.Unlisten(p.key, listener); call != nil {
+			call.Delivered(r.Context().Err())
+		}
+		return
+	}
+
+	w.Header().Set("X-Request-Id", call.ID)
+	w.Header().Set("X-Reply-Url", fmt.Sprintf("%s%s/reply/%s", s.baseURL, p.key, call.ID))
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Expose-Headers", "X-Request-Id, X-Reply-Url")
+	if len(call.ContentType) > 0 {
+		w.Header().Set("Content-Type", call.ContentType)
+	}
+	flusher, _ := w.(http.Flusher)
+	_, err := io.Copy(flushWriter{writer: w, flusher: flusher}, call.Body)
+	call.Delivered(err)
+}
+
+// send an rpc request to one responder and stream its reply back
+func (s *server) rpcCall(w http.ResponseWriter, r *http.Request, p *params) {
+	call, err := s.rpc.Call(p.key, r.Body, r.Header.Get("Content-Type"))
+	if err != nil {
+		http.Error(w, "No responders available", http.StatusServiceUnavailable)
+		return
+	}
+	defer s.rpc.Finish(call)
+
+	timeout := s.rpcTimeout
+	if timeout <= 0 {
+		timeout = defaultRPCTimeout
+	}
+	select {
+	case err := <-call.delivered:
+		if err != nil {
+			http.Error(w, "Responder disconnected", http.StatusBadGateway)
 			return
-		// a sender was added to the pipe - continue on
-		case <-receiverAdded:
 		}
+	case <-time.After(timeout):
+		http.Error(w, fmt.Sprintf("Request not read within %s", timeout), http.StatusGatewayTimeout)
+		return
+	case <-r.Context().Done():
+		return
 	}
 
-	// upload size limit
-	body := http.MaxBytesReader(w, r.Body, maxUploadMb*1024*1024)
+	var reply *RPCReply
+	select {
+	case reply = <-call.reply:
+	case <-time.After(timeout):
+		http.Error(w, fmt.Sprintf("No reply within %s", timeout), http.StatusGatewayTimeout)
+		return
+	case <-r.Context().Done():
+		return
+	}
 
-	// copy the request body to all senders
-	sender := MakeSender(pipe, p.id, p.username)
-	go sender.Copy(body)
+	w.Header().Set("X-Request-Id", call.ID)
+	if len(reply.ContentType) > 0 {
+		w.Header().Set("Content-Type", reply.ContentType)
+	}
+	flusher, _ := w.(http.Flusher)
+	_, err = io.Copy(flushWriter{writer: w, flusher: flusher}, reply.Body)
+	reply.done <- err
+}
+
+// stream a responder's reply back to the sender of the request
+func (s *server) rpcReply(w http.ResponseWriter, r *http.Request, key, id string) {
+	if r.Method != "POST" && r.Method != "PUT" {
+		http.Error(w, "Invalid Method", http.StatusNotFound)
+		return
+	}
+	reply := &RPCReply{Body: r.Body, ContentType: r.Header.Get("Content-Type")}
+	call, err := s.rpc.Reply(key, id, reply)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("No request waiting for reply %s", id), http.StatusNotFound)
+		return
+	}
+
+	select {
+	case err = <-reply.done:
+	case <-call.finished:
+		// the sender may have finished right after reading the whole reply
+		select {
+		case err = <-reply.done:
+		default:
+			http.Error(w, "The sender stopped waiting", http.StatusGone)
+			return
+		}
+	case <-r.Context().Done():
+		return
+	}
+	if err != nil {
+		http.Error(w, "Sender disconnected", http.StatusBadGateway)
+		return
+	}
+	fmt.Fprintf(w, "delivered\n")
+}
+
+// expire cleans up the file transfers and recordings that have been left for too long
+// without it an abandoned upload keeps its spool file until someone claims another file pipe
+func (s *server) expire(now time.Time) {
+	s.allPipes.ExpireFiles(now)
+	if len(s.recordDir) > 0 {
+		ExpireRecordings(s.recordDir, s.retention, now)
+	}
+}
+
+// blockWaitFor returns how long a connection waits for the other side in block and file mode
+// clients can ask for a shorter wait than the server's with ?wait=
+func (s *server) blockWaitFor(p *params) time.Duration {
+	wait := s.blockWait
+	if wait <= 0 {
+		wait = defaultBlockWait
+	}
+	if p.wait > 0 && p.wait < wait {
+		wait = p.wait
+	}
+	return wait
+}
+
+// wait for a receiver to connect to the pipe
+// returns ErrNoReceiver if no one connected in time or the context error if the sender disconnected first
+func waitForReceiver(r *http.Request, pipe *Pipe, wait time.Duration) error {
+	receiverAdded := pipe.ReceiverAddedSubscribe()
+	defer pipe.ReceiverAddedUnSubscribe(receiverAdded)
+	if pipe.ListenerCount() > 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	// the sender disconnected before a receiver connected
+	case <-r.Context().Done():
+		return r.Context().Err()
+	// no receiver connected in the time the sender was willing to wait
+	case <-timer.C:
+		return ErrNoReceiver
+	// a receiver was added to the pipe - continue on
+	case <-receiverAdded:
+		return nil
+	}
+}
 
-	// The 100-continue message is sent on the first read from the Copy goroutine above
-	// A short delay is needed to ensure that it goes out before any data is writen back
-	time.Sleep(10 * time.Millisecond)
+// receiverWaitError tells the client why it stopped waiting for the other side of the pipe
+func receiverWaitError(w http.ResponseWriter, err error, wait time.Duration) {
+	switch err {
+	case ErrNoReceiver:
+		http.Error(w, fmt.Sprintf("No receivers connected within %s", wait), http.StatusGatewayTimeout)
+		return
+	case ErrNoSender:
+		http.Error(w, fmt.Sprintf("No sender connected within %s", wait), http.StatusGatewayTimeout)
+		return
+	}
+	// the client is gone so the status only shows up in logs
+	w.WriteHeader(statusClientClosed)
+}
 
-	s.recv(w, r, p)
+// continueUpload sends the 100 Continue a client with Expect: 100-continue waits for before sending its body
+// net/http would send it on the first read of the body, which races with a response written at the same time
+// writing it explicitly puts it on the wire before anything else the handler writes
+func continueUpload(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoAtLeast(1, 1) && r.ContentLength != 0 && strings.EqualFold(r.Header.Get("Expect"), "100-continue") {
+		w.WriteHeader(http.StatusContinue)
+	}
+}
+
+// write the error for a one-shot file pipe that can't be claimed
+func fileClaimError(w http.ResponseWriter, err error) {
+	if err == ErrKeyRetired {
+		http.Error(w, "Pipe has been retired", http.StatusGone)
+		return
+	}
+	http.Error(w, "Pipe already in use", http.StatusConflict)
+}
+
+// receive a single file from a single sender
+// a Range header resumes a previous download from a byte offset
+func (s *server) recvFile(w http.ResponseWriter, r *http.Request, p *params) {
+	pipe, err := s.allPipes.ClaimFile(p.key, false)
+	if err != nil {
+		fileClaimError(w, err)
+		return
+	}
+	defer s.allPipes.ReleaseFile(p.key, pipe, false)
+	transfer := pipe.Transfer()
+
+	// describe the data from the sender before any of it is written
+	wait := s.blockWaitFor(p)
+	info, err := waitForFileInfo(r, pipe, wait)
+	if err != nil {
+		receiverWaitError(w, err, wait)
+		return
+	}
+	if len(p.filename) > 0 {
+		info.Filename = cleanFilename(p.filename)
+	}
+	total := transfer.Total()
+	info.ContentLength = total
+
+	// compressed uploads are passed through untouched if the receiver accepts them
+	// otherwise they are decompressed and the size and byte ranges are no longer known
+	compressed, _ := isGzip(info.ContentEncoding)
+	decompress := compressed && !acceptsGzip(r)
+	if compressed && !decompress {
+		w.Header().Set("Content-Encoding", info.ContentEncoding)
+	}
+	if decompress {
+		info.ContentLength = -1
+	} else {
+		w.Header().Set("Accept-Ranges", "bytes")
+	}
+
+	// resume from the requested offset
+	start, end := int64(0), int64(-1)
+	status := http.StatusOK
+	if rng, ok := ParseRange(r.Header.Get("Range")); ok && !decompress {
+		if total < 0 {
+			http.Error(w, "Range requires a known file size", http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if rng.Start >= total {
+			w.Header().Set("Content-Range", unsatisfiedRange(total))
+			http.Error(w, "Range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if rng.End < 0 || rng.End >= total {
+			rng.End = total - 1
+		}
+		rng.Total = total
+		start, end = rng.Start, rng.End
+		status = http.StatusPartialContent
+		info.ContentLength = rng.Length()
+		w.Header().Set("Content-Range", rng.ContentRange())
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	info.WriteHeaders(w.Header())
+
+	w.WriteHeader(status)
+	flusher, _ := w.(http.Flusher)
+	receiver := MakeReceiver(w, flusher, p.id, RawFormatter{}, p.username)
+	s.allPipes.AddReceiver(p.key, receiver)
+	defer s.allPipes.RemoveReceiver(p.key, receiver)
+	defer receiver.Stop()
+
+	// follow the uploaded data as it arrives
+	spool := transfer.Spool()
+	spoolReader := spool.NewReader(r.Context(), start)
+	var reader io.Reader = spoolReader
+	if end >= 0 {
+		reader = io.LimitReader(spoolReader, end-start+1)
+	}
+	if decompress {
+		reader = &lazyGzipReader{reader: reader}
+	}
+	_, err = io.Copy(receiver, reader)
+	if err == ErrSpoolDiscarded {
+		// the transfer expired - drop the connection so that the client sees an incomplete transfer
+		panic(http.ErrAbortHandler)
+	}
+	if err != nil {
+		// the receiver disconnected - the download can be resumed
+		return
+	}
+
+	offset := spoolReader.Offset()
+	if (total >= 0 && offset == total) || (spool.Finished() && offset == spool.Size()) {
+		transfer.Finish(nil)
+	}
+}
+
+// wait for a sender to connect and describe the data it is sending
+// returns ErrNoSender if no sender connected within the wait or the context error if the receiver disconnected first
+func waitForFileInfo(r *http.Request, pipe *Pipe, wait time.Duration) (*FileInfo, error) {
+	if info := pipe.FileInfo(); info != nil {
+		described := *info
+		return &described, nil
+	}
+
+	fileAdded := pipe.FileInfoSubscribe()
+	defer pipe.FileInfoUnSubscribe(fileAdded)
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	// the receiver disconnected before a sender connected
+	case <-r.Context().Done():
+		return nil, r.Context().Err()
+	// no sender connected in the time the receiver was willing to wait
+	case <-timer.C:
+		return nil, ErrNoSender
+	// a sender described the file
+	case <-fileAdded:
+	}
+	described := *pipe.FileInfo()
+	return &described, nil
+}
+
+// send a single file to a single receiver and report when it has been delivered
+// a Content-Range header resumes a previous upload from the byte offset already received
+func (s *server) sendFile(w http.ResponseWriter, r *http.Request, p *params) {
+	pipe, err := s.allPipes.ClaimFile(p.key, true)
+	if err != nil {
+		fileClaimError(w, err)
+		return
+	}
+	defer s.allPipes.ReleaseFile(p.key, pipe, true)
+	transfer := pipe.Transfer()
+
+	if _, err := s.allPipes.AddSender(p.key, p.token); err != nil {
+		http.Error(w, "Only the owner can send to a broadcast pipe", http.StatusForbidden)
+		return
+	}
+	defer s.allPipes.RemoveSender(p.key, pipe)
+
+	// compressed uploads are stored as they are sent
+	if _, err := isGzip(r.Header.Get("Content-Encoding")); err != nil {
+		http.Error(w, "Unsupported Content-Encoding", http.StatusUnsupportedMediaType)
+		return
+	}
+	if err := transfer.OpenSpool(s.spoolDir); err != nil {
+		log.Println("Unable to create spool:", err)
+		http.Error(w, "Unable to buffer upload", http.StatusInternalServerError)
+		return
+	}
+	spool := transfer.Spool()
+
+	// a resumed upload must continue from the end of the data already received
+	offset, length, total := int64(0), int64(-1), r.ContentLength
+	if contentRange := r.Header.Get("Content-Range"); len(contentRange) > 0 {
+		rng, ok := ParseContentRange(contentRange)
+		if !ok {
+			http.Error(w, "Invalid Content-Range", http.StatusBadRequest)
+			return
+		}
+		offset, length, total = rng.Start, rng.Length(), rng.Total
+	}
+	if offset != spool.Size() {
+		w.Header().Set("Content-Range", unsatisfiedRange(spool.Size()))
+		http.Error(w, fmt.Sprintf("Resume from byte %d", spool.Size()), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	transfer.SetTotal(total)
+
+	info := ParseFileInfo(r, p.filename)
+	info.ContentLength = transfer.Total()
+	pipe.SetFileInfo(info)
+
+	// wait for the receiver to connect before the first upload
+	if offset == 0 {
+		wait := s.blockWaitFor(p)
+		if err := waitForReceiver(r, pipe, wait); err != nil {
+			receiverWaitError(w, err, wait)
+			return
+		}
+	}
+
+	var body io.Reader = r.Body
+	if length >= 0 {
+		body = io.LimitReader(body, length)
+	}
+
+	// upload limits
+	session := MakeSessionReader(body, pipe.Limits(), pipe)
+	defer session.Stop()
+
+	start := time.Now()
+	var written int64
+	copied := make(chan error, 1)
+	go func() {
+		var err error
+		written, err = io.Copy(countingWriter{pipe, spool}, session)
+		copied <- err
+	}()
+	select {
+	case err = <-copied:
+	case <-session.Idle():
+		err = session.Err()
+	}
+	if limitErr, ok := err.(*LimitError); ok {
+		http.Error(w, fmt.Sprintf("%s at byte %d", limitErr, spool.Size()), limitErr.Status())
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Upload interrupted at byte %d", spool.Size()), http.StatusBadRequest)
+		return
+	}
+	if transfer.Total() >= 0 && spool.Size() < transfer.Total() {
+		// the rest of the file will be sent in another request
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "received %d of %d bytes\n", spool.Size(), transfer.Total())
+		return
+	}
+	spool.Finish()
+
+	select {
+	// the sender disconnected before the receiver finished
+	case <-r.Context().Done():
+		return
+	// allow a timeout if the receiver never finishes
+	case <-time.After(s.blockWaitFor(p)):
+	// the transfer was discarded
+	case <-transfer.Aborted():
+	// the receiver read the whole file
+	case <-transfer.Done():
+	}
+	if !transfer.Complete() {
+		http.Error(w, "File was not delivered", http.StatusInternalServerError)
+		return
+	}
+	if p.receipt {
+		receipt := Receipt{BytesSent: written, Delivered: 1, Duration: time.Since(start)}
+		receipt.Write(w, r)
+		return
+	}
+	fmt.Fprintf(w, "delivered %d bytes\n", spool.Size())
 }
 
 func main() {
@@ -207,14 +1542,126 @@ func main() {
 	baseurl := flag.String("baseurl", "http://localhost:8080/",
 		"the base url of the service \n")
 
+	// Accept command line flags for the default limits on all pipes
+	maxmb := flag.Int64("maxmb", 64,
+		"the maximum size of a single upload in MB (0 for unlimited) \n")
+	pipemb := flag.Int64("pipemb", 0,
+		"the maximum data sent through a single pipe in MB (0 for unlimited) \n")
+	rate := flag.Int64("rate", 0,
+		"the maximum rate of a single upload in KB/s (0 for unlimited) \n")
+	idle := flag.Duration("idle", 0,
+		"the time an upload can go without sending data (0 for unlimited) \n")
+
+	// Accept a command line flag "-spooldir /var/tmp"
+	spooldir := flag.String("spooldir", os.TempDir(),
+		"the directory used to buffer resumable file transfers \n")
+
+	// Accept a command line flag "-templatedir /etc/pipe-to-me"
+	templatedir := flag.String("templatedir", "",
+		"a directory of templates to use instead of the built in ones \n"+
+			"(home.txt, home.html, stats.txt)\n")
+
+	// Accept command line flags that describe the instance in the templates
+	name := flag.String("name", "pipeto.me",
+		"the name of this instance shown on the home page \n")
+	contact := flag.String("contact", "",
+		"how to reach the operator shown on the home page \n")
+	modelist := flag.String("modes", strings.Join(allModes, ","),
+		"a comma separated list of the modes that are enabled \n")
+
+	// Accept a command line flag "-keys words"
+	keystyle := flag.String("keys", "random",
+		"the style of generated keys: "+strings.Join(KeyStyleNames(), ", ")+" \n")
+
+	// Accept a command line flag "-rpctimeout 1m"
+	rpctimeout := flag.Duration("rpctimeout", defaultRPCTimeout,
+		"how long an rpc request waits to be read and then replied to \n")
+
+	// Accept a command line flag "-blockwait 1h"
+	blockwait := flag.Duration("blockwait", defaultBlockWait,
+		"the longest a connection waits for the other side in block and file mode (clients can lower it with ?wait=) \n")
+
+	// Accept command line flags for recording pipes with ?record=1
+	recorddir := flag.String("recorddir", "",
+		"the directory where pipes are recorded (recording is disabled if empty) \n")
+	recordmb := flag.Int64("recordmb", 100,
+		"the maximum size of a single recording in MB (0 for unlimited) \n")
+	retention := flag.Duration("retention", 7*24*time.Hour,
+		"how long recordings are kept after they were last written to \n")
+
+	// Accept command line flags for keeping long-lived receivers alive
+	heartbeat := flag.Duration("heartbeat", 0,
+		"how often receivers on a quiet pipe are sent a heartbeat (0 unless they ask with ?heartbeat=) \n")
+	heartbeatdata := flag.String("heartbeatdata", "",
+		"the heartbeat sent to raw receivers, with go escapes like \\n (sse receivers get comment lines) \n")
+	recvidle := flag.Duration("recvidle", 0,
+		"the time a receiver can go without data before it is disconnected (0 for unlimited) \n")
+	writetimeout := flag.Duration("writetimeout", defaultWriteTimeout,
+		"how long a write to a receiver can take before the receiver is disconnected \n")
+
+	// Accept a command line flag "-reservations /var/lib/pipe-to-me/reservations.json"
+	reservefile := flag.String("reservations", "",
+		"the file where reserved keys are saved (kept in memory if empty) \n")
+
 	flag.Parse()
 
+	modes, err := parseModes(*modelist)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, exists := FindKeyStyle(*keystyle); !exists {
+		log.Fatal("Unknown key style: ", *keystyle)
+	}
+
+	reservations, err := LoadReservations(*reservefile)
+	if err != nil {
+		log.Fatal("Error loading reservations: ", err)
+	}
+
+	// fail fast if an override has a syntax error or refers to a field that doesn't exist
+	tmpl, err := loadTemplates(*templatedir)
+	if err == nil {
+		err = tmpl.Validate(templateSamples)
+	}
+	if err != nil {
+		log.Fatal("Error loading templates: ", err)
+	}
+	go tmpl.Watch(2*time.Second, templateSamples)
+
 	s := server{
 		allPipes:  MakePipeCollection(),
 		baseURL:   *baseurl,
-		maxID:     0,
-		templates: templates(),
+		templates: tmpl,
+		spoolDir:  *spooldir,
+		name:      *name,
+		contact:   *contact,
+		modes:     modes,
+		keyStyle:  *keystyle,
+
+		reservations: reservations,
+		rpc:          MakeRPCBroker(),
+		rpcTimeout:   *rpctimeout,
+		blockWait:    *blockwait,
+		recordDir:    *recorddir,
+		recordBytes:  *recordmb * 1024 * 1024,
+		retention:    *retention,
+
+		heartbeat:     *heartbeat,
+		heartbeatData: parseHeartbeatData(*heartbeatdata),
+		recvTimeout:   *recvidle,
+		writeTimeout:  *writetimeout,
 	}
+	s.allPipes.SetLimits(Limits{
+		SessionBytes:   *maxmb * 1024 * 1024,
+		PipeBytes:      *pipemb * 1024 * 1024,
+		BytesPerSecond: *rate * 1024,
+		IdleTimeout:    *idle,
+	})
+	go func() {
+		for now := range time.Tick(expireInterval) {
+			s.expire(now)
+		}
+	}()
 	http.HandleFunc("/stats", s.stats)
 	http.HandleFunc("/", s.handler)
 
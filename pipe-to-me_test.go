@@ -0,0 +1,1239 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func makeTestServer() (*server, *httptest.Server) {
+	reservations, _ := LoadReservations("")
+	s := &server{
+		allPipes:     MakePipeCollection(),
+		templates:    templates(),
+		reservations: reservations,
+		rpc:          MakeRPCBroker(),
+	}
+	ts := httptest.NewServer(http.HandlerFunc(s.handler))
+	s.baseURL = ts.URL + "/"
+	return s, ts
+}
+
+func TestFileMode(t *testing.T) {
+	_, ts := makeTestServer()
+	defer ts.Close()
+	url := ts.URL + "/filekey?mode=file"
+	input := "test file contents"
+
+	type result struct {
+		resp *http.Response
+		body string
+	}
+	received := make(chan result)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Errorf("Error receiving file: %s", err.Error())
+			received <- result{}
+			return
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		received <- result{resp, string(body)}
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// a second receiver is rejected
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("Error connecting second receiver: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("Invalid status for second receiver: %d %d", http.StatusConflict, resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest("PUT", url+"&filename=input.txt", strings.NewReader(input))
+	req.Header.Set("Content-Type", "text/csv")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error sending file: %s", err.Error())
+	}
+	sent, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Invalid sender status: %d %d", http.StatusOK, resp.StatusCode)
+	}
+	if string(sent) != "delivered 18 bytes\n" {
+		t.Errorf("Invalid sender response: %q", string(sent))
+	}
+
+	r := <-received
+	if r.resp == nil {
+		t.FailNow()
+	}
+	if r.body != input {
+		t.Errorf("Invalid file received: %s %s", input, r.body)
+	}
+	if r.resp.Header.Get("Content-Type") != "text/csv" {
+		t.Errorf("Invalid content type: %s", r.resp.Header.Get("Content-Type"))
+	}
+	if r.resp.Header.Get("Content-Disposition") != `attachment; filename=input.txt` {
+		t.Errorf("Invalid content disposition: %s", r.resp.Header.Get("Content-Disposition"))
+	}
+	if r.resp.ContentLength != int64(len(input)) {
+		t.Errorf("Invalid content length: %d %d", len(input), r.resp.ContentLength)
+	}
+
+	// the key is retired after the transfer
+	resp, err = http.Get(url)
+	if err != nil {
+		t.Fatalf("Error connecting after transfer: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusGone {
+		t.Errorf("Invalid status after transfer: %d %d", http.StatusGone, resp.StatusCode)
+	}
+}
+
+func TestFileModeWait(t *testing.T) {
+	s, ts := makeTestServer()
+	defer ts.Close()
+	s.blockWait = 50 * time.Millisecond
+
+	// a receiver stops waiting for a sender after the server's wait
+	done := make(chan int)
+	go func() {
+		status := 0
+		if resp, err := http.Get(ts.URL + "/waitkey?mode=file"); err == nil {
+			status = resp.StatusCode
+			resp.Body.Close()
+		}
+		done <- status
+	}()
+	select {
+	case status := <-done:
+		if status != http.StatusGatewayTimeout {
+			t.Errorf("Invalid status for a receiver without a sender: %d", status)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("File receiver should stop waiting after %s", s.blockWait)
+	}
+	if s.allPipes.FindPipe("waitkey") != nil {
+		t.Errorf("File pipe should be deleted once the receiver gives up")
+	}
+}
+
+func TestExpireAbandonedFile(t *testing.T) {
+	s, ts := makeTestServer()
+	defer ts.Close()
+	s.spoolDir = t.TempDir()
+
+	pipe, _ := s.allPipes.ClaimFile("abandoned", true)
+	pipe.Transfer().OpenSpool(s.spoolDir)
+	pipe.Transfer().Spool().Write([]byte("test input"))
+	s.allPipes.ReleaseFile("abandoned", pipe, true)
+
+	// the periodic sweep removes the upload without another file pipe being claimed
+	s.expire(time.Now().Add(resumeTimeout + time.Minute))
+	if s.allPipes.IsFilePipe("abandoned") || !s.allPipes.IsRetired("abandoned") {
+		t.Errorf("Abandoned file pipe should be expired and retired")
+	}
+	if files, _ := os.ReadDir(s.spoolDir); len(files) > 0 {
+		t.Errorf("Spool file should be removed: %v", files)
+	}
+}
+
+func TestFailModeReceiver(t *testing.T) {
+	s, ts := makeTestServer()
+	defer ts.Close()
+
+	// the headers are sent before the receiver is added so the refusal has to come first
+	resp, err := http.Get(ts.URL + "/failkey?mode=fail")
+	if err != nil {
+		t.Fatalf("Error receiving: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Invalid status without senders: %d", resp.StatusCode)
+	}
+	if s.allPipes.FindPipe("failkey") != nil {
+		t.Errorf("Pipe should be deleted for a refused receiver")
+	}
+}
+
+func TestFileModeConflict(t *testing.T) {
+	s, ts := makeTestServer()
+	defer ts.Close()
+
+	s.allPipes.AddSender("busykey", "")
+	resp, err := http.Get(ts.URL + "/busykey?mode=file")
+	if err != nil {
+		t.Fatalf("Error connecting receiver: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("Invalid status for pipe in use: %d %d", http.StatusConflict, resp.StatusCode)
+	}
+}
+
+func TestSendReceipt(t *testing.T) {
+	_, ts := makeTestServer()
+	defer ts.Close()
+	url := ts.URL + "/receiptkey"
+
+	received := make(chan string)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			received <- ""
+			return
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		received <- string(body)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	req, _ := http.NewRequest("PUT", url+"?receipt=1", strings.NewReader("test input"))
+	req.Header.Set("Accept", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error sending: %s", err.Error())
+	}
+	sent, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if !strings.HasPrefix(string(sent), `{"bytes_sent":10,"delivered":1,"disconnected":0,`) {
+		t.Errorf("Invalid receipt: %s", string(sent))
+	}
+	if body := <-received; body != "test input" {
+		t.Errorf("Invalid data received: %s %s", "test input", body)
+	}
+}
+
+func TestFileModeResume(t *testing.T) {
+	_, ts := makeTestServer()
+	defer ts.Close()
+	url := ts.URL + "/resumekey?mode=file"
+
+	received := make(chan *http.Response)
+	go func() {
+		req, _ := http.NewRequest("GET", url, nil)
+		req.Header.Set("Range", "bytes=3-")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("Error receiving file: %s", err.Error())
+		}
+		received <- resp
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	upload := func(body string, contentRange string) (*http.Response, string) {
+		req, _ := http.NewRequest("PUT", url, strings.NewReader(body))
+		if len(contentRange) > 0 {
+			req.Header.Set("Content-Range", contentRange)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Error sending file: %s", err.Error())
+		}
+		sent, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp, string(sent)
+	}
+
+	resp, sent := upload("01234", "bytes 0-4/10")
+	if resp.StatusCode != http.StatusAccepted || sent != "received 5 of 10 bytes\n" {
+		t.Errorf("Invalid partial upload response: %d %s", resp.StatusCode, sent)
+	}
+
+	resp, _ = upload("0123456789", "")
+	if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("Invalid status for upload from the wrong offset: %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Content-Range") != "bytes */5" {
+		t.Errorf("Invalid resume offset: %s", resp.Header.Get("Content-Range"))
+	}
+
+	resp, sent = upload("56789", "bytes 5-9/10")
+	if resp.StatusCode != http.StatusOK || sent != "delivered 10 bytes\n" {
+		t.Errorf("Invalid resumed upload response: %d %s", resp.StatusCode, sent)
+	}
+
+	r := <-received
+	if r == nil {
+		t.FailNow()
+	}
+	body, _ := io.ReadAll(r.Body)
+	r.Body.Close()
+	if r.StatusCode != http.StatusPartialContent {
+		t.Errorf("Invalid status for resumed download: %d", r.StatusCode)
+	}
+	if r.Header.Get("Content-Range") != "bytes 3-9/10" {
+		t.Errorf("Invalid content range: %s", r.Header.Get("Content-Range"))
+	}
+	if string(body) != "3456789" {
+		t.Errorf("Invalid resumed download: %s %s", "3456789", string(body))
+	}
+}
+
+func TestSendLimit(t *testing.T) {
+	s, ts := makeTestServer()
+	defer ts.Close()
+	s.allPipes.SetLimits(Limits{SessionBytes: 4})
+	url := ts.URL + "/limitkey?mode=interactive"
+
+	received := make(chan string)
+	go func() {
+		resp, err := http.Get(url + "&user=bob")
+		if err != nil {
+			received <- ""
+			return
+		}
+		buffer := make([]byte, 1024)
+		output := ""
+		for !strings.Contains(output, "limit") {
+			n, err := resp.Body.Read(buffer)
+			output += string(buffer[:n])
+			if err != nil {
+				break
+			}
+		}
+		resp.Body.Close()
+		received <- output
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	req, _ := http.NewRequest("PUT", url+"&user=alice", strings.NewReader("test input"))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error sending: %s", err.Error())
+	}
+	sent, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if !strings.HasSuffix(string(sent), "upload limit of 4 bytes reached\n") {
+		t.Errorf("Limit not reported to sender: %q", string(sent))
+	}
+	if output := <-received; !strings.Contains(output, "alice: upload limit of 4 bytes reached\n") {
+		t.Errorf("Limit not reported to receiver: %q", output)
+	}
+}
+
+func TestWebClient(t *testing.T) {
+	_, ts := makeTestServer()
+	defer ts.Close()
+
+	req, _ := http.NewRequest("GET", ts.URL+"/webkey", nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error getting web client: %s", err.Error())
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") || !strings.Contains(string(page), "/static/app.js") {
+		t.Errorf("Invalid web client page: %s %s", resp.Header.Get("Content-Type"), string(page))
+	}
+
+	resp, err = http.Get(ts.URL + "/static/app.js")
+	if err != nil {
+		t.Fatalf("Error getting static asset: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(resp.Header.Get("Content-Type"), "javascript") {
+		t.Errorf("Invalid static asset: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+}
+
+func TestMessageMode(t *testing.T) {
+	s, ts := makeTestServer()
+	defer ts.Close()
+
+	// the browser client receives with server-sent events and posts each chat line
+	resp, err := http.Get(ts.URL + "/chatkey?format=sse&user=bob")
+	if err != nil {
+		t.Fatalf("Error connecting receiver: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+	for s.allPipes.FindPipe("chatkey").ReceiverCount() < 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, line := range []string{"hello", "again"} {
+		post, err := http.Post(ts.URL+"/chatkey?msg=1&user=alice", "text/plain", strings.NewReader(line+"\n"))
+		if err != nil {
+			t.Fatalf("Error sending message: %s", err.Error())
+		}
+		post.Body.Close()
+		if post.StatusCode != http.StatusNoContent {
+			t.Errorf("Invalid message status: %d", post.StatusCode)
+		}
+		if _, err := readUntil(reader, line); err != nil {
+			t.Fatalf("Message %q not received: %s", line, err.Error())
+		}
+	}
+	if count := s.allPipes.FindPipe("chatkey").ReceiverCount(); count != 1 {
+		t.Errorf("Receiver closed by a message: %d receivers", count)
+	}
+}
+
+func TestUsers(t *testing.T) {
+	_, ts := makeTestServer()
+	defer ts.Close()
+
+	// curl still gets the stream
+	resp, err := http.Get(ts.URL + "/userkey?user=bob")
+	if err != nil {
+		t.Fatalf("Error connecting receiver: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Errorf("Invalid receiver content type: %s", resp.Header.Get("Content-Type"))
+	}
+
+	resp, err = http.Get(ts.URL + "/userkey?users")
+	if err != nil {
+		t.Fatalf("Error listing users: %s", err.Error())
+	}
+	users, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if strings.TrimSpace(string(users)) != `[{"id":1,"user":"bob"}]` {
+		t.Errorf("Invalid users: %s", string(users))
+	}
+}
+
+func TestHomeNegotiation(t *testing.T) {
+	_, ts := makeTestServer()
+	defer ts.Close()
+
+	get := func(path, accept string) (*http.Response, string) {
+		req, _ := http.NewRequest("GET", ts.URL+path, nil)
+		req.Header.Set("Accept", accept)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Error getting %s: %s", path, err.Error())
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp, string(body)
+	}
+
+	_, body := get("/new", "*/*")
+	if !strings.HasPrefix(body, ts.URL+"/") || strings.Contains(body, "\n") {
+		t.Errorf("Invalid text url: %s", body)
+	}
+	_, body = get("/", "*/*")
+	if !strings.Contains(body, "PIPE TO ME") {
+		t.Errorf("Invalid text home: %s", body)
+	}
+
+	for _, path := range []string{"/", "/new"} {
+		resp, body := get(path, "text/html,*/*;q=0.8")
+		if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") || !strings.Contains(body, "data-copy") {
+			t.Errorf("Invalid html for %s: %s", path, body)
+		}
+
+		resp, body = get(path, "application/json")
+		var links pipeLinks
+		if err := json.Unmarshal([]byte(body), &links); err != nil {
+			t.Errorf("Invalid json for %s: %s", path, body)
+		}
+		if links.URL != ts.URL+"/"+links.Key || links.Recv != "curl "+links.URL || links.Send != "curl -T- "+links.URL {
+			t.Errorf("Invalid links for %s: %+v", path, links)
+		}
+	}
+}
+
+func TestNewKeyStyle(t *testing.T) {
+	s, ts := makeTestServer()
+	defer ts.Close()
+
+	get := func(path string) (*http.Response, pipeLinks) {
+		req, _ := http.NewRequest("GET", ts.URL+path, nil)
+		req.Header.Set("Accept", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Error getting %s: %s", path, err.Error())
+		}
+		defer resp.Body.Close()
+		var links pipeLinks
+		json.NewDecoder(resp.Body).Decode(&links)
+		return resp, links
+	}
+
+	_, links := get("/new")
+	if links.Style != "random" || len(links.Key) != keySize {
+		t.Errorf("Invalid default key: %+v", links)
+	}
+	_, links = get("/new?style=words")
+	if links.Style != "words" || strings.Count(links.Key, "-") != 3 || links.Entropy < 30 {
+		t.Errorf("Invalid word key: %+v", links)
+	}
+	if !keyRegex.MatchString("/" + links.Key) {
+		t.Errorf("Word key doesn't match the key pattern: %s", links.Key)
+	}
+	s.keyStyle = "words"
+	_, links = get("/")
+	if links.Style != "words" {
+		t.Errorf("Invalid server default style: %+v", links)
+	}
+	resp, _ := get("/new?style=unknown")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected an error for an unknown style: %d", resp.StatusCode)
+	}
+}
+
+func TestNewQRCode(t *testing.T) {
+	_, ts := makeTestServer()
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/new?qr=1")
+	if err != nil {
+		t.Fatalf("Error getting qr code: %s", err.Error())
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	lines := strings.SplitN(string(body), "\n", 2)
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], ts.URL+"/") {
+		t.Fatalf("Invalid qr response: %s", string(body))
+	}
+	qr, _ := EncodeQR([]byte(lines[0]))
+	if lines[1] != qr.Terminal() {
+		t.Errorf("Invalid qr code: %s", lines[1])
+	}
+
+	resp, err = http.Get(ts.URL + "/?qr=1")
+	if err != nil {
+		t.Fatalf("Error getting home: %s", err.Error())
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "▀") {
+		t.Errorf("Expected qr code in home page: %s", string(body))
+	}
+}
+
+func TestDisabledMode(t *testing.T) {
+	s, ts := makeTestServer()
+	defer ts.Close()
+	s.modes = []string{"fail"}
+
+	resp, err := http.Get(ts.URL + "/modekey?mode=interactive")
+	if err != nil {
+		t.Fatalf("Error connecting: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Invalid status for disabled mode: %d %d", http.StatusForbidden, resp.StatusCode)
+	}
+}
+
+func TestReservedKey(t *testing.T) {
+	_, ts := makeTestServer()
+	defer ts.Close()
+	url := ts.URL + "/deploys"
+
+	do := func(method, url, secret string) (int, string) {
+		req, _ := http.NewRequest(method, url, nil)
+		if len(secret) > 0 {
+			req.SetBasicAuth("", secret)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Error requesting %s %s: %s", method, url, err.Error())
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode, strings.TrimSpace(string(body))
+	}
+
+	if status, _ := do("PUT", url+"?reserve", ""); status != http.StatusUnauthorized {
+		t.Errorf("Expected a secret to be required: %d", status)
+	}
+	if status, _ := do("PUT", url+"?reserve&mode=file", "secret"); status != http.StatusBadRequest {
+		t.Errorf("Expected file mode to be rejected: %d", status)
+	}
+	status, body := do("PUT", url+"?reserve&mode=interactive&replay=100&maxreceivers=1", "secret")
+	if status != http.StatusCreated || body != "deploys reserved (mode: interactive, replay: 100 bytes, max receivers: 1)" {
+		t.Errorf("Invalid reservation: %d %s", status, body)
+	}
+	if status, _ := do("PUT", url+"?reserve", "other"); status != http.StatusForbidden {
+		t.Errorf("Expected the key to be taken: %d", status)
+	}
+
+	// nobody else can create the pipe
+	if status, _ := do("GET", url, ""); status != http.StatusForbidden {
+		t.Errorf("Expected the pipe to be reserved: %d", status)
+	}
+
+	// the owner creates it with the defaults and then anyone can join up to the limit
+	resp, err := http.Get(url + "?secret=secret&user=owner")
+	if err != nil {
+		t.Fatalf("Error connecting owner: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	if status, body := do("GET", url, ""); status != http.StatusServiceUnavailable {
+		t.Errorf("Expected the pipe to be full: %d %s", status, body)
+	}
+	if line, err := readUntil(bufio.NewReader(resp.Body), "connected"); err != nil || !strings.Contains(line, "owner") {
+		t.Errorf("Expected the default interactive mode: %s %v", line, err)
+	}
+
+	if status, body := do("DELETE", url+"?reserve", "secret"); status != http.StatusOK || body != "deploys released" {
+		t.Errorf("Invalid release: %d %s", status, body)
+	}
+	if status, _ := do("GET", url+"?reserve", ""); status != http.StatusNotFound {
+		t.Errorf("Expected the key to be released: %d", status)
+	}
+}
+
+func TestWildcardSubscription(t *testing.T) {
+	_, ts := makeTestServer()
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/team/builds/*?format=jsonl")
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+
+	send := func(key, body string) int {
+		resp, err := http.Post(ts.URL+key, "text/plain", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Error sending: %s", err.Error())
+		}
+		io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	send("/team/other", "not matched\n")
+	send("/team/builds/linux", "build passed\n")
+
+	line, err := readUntil(reader, "build passed")
+	if err != nil {
+		t.Fatalf("Error receiving: %s", err.Error())
+	}
+	var m jsonMessage
+	json.Unmarshal([]byte(line), &m)
+	if m.Key != "team/builds/linux" || m.Data != "build passed\n" {
+		t.Errorf("Invalid message: %s", line)
+	}
+
+	if status := send("/team/builds/*", "hello"); status != http.StatusBadRequest {
+		t.Errorf("Expected wildcard sends to fail: %d", status)
+	}
+	if status := send("/team/**/linux", "hello"); status != http.StatusBadRequest {
+		t.Errorf("Expected an invalid pattern to fail: %d", status)
+	}
+}
+
+func TestWildcardReceiverCounts(t *testing.T) {
+	_, ts := makeTestServer()
+	defer ts.Close()
+
+	// a block mode sender is released by a wildcard receiver that subscribes after it connected
+	sent := make(chan int)
+	go func() {
+		status := 0
+		if resp, err := http.Post(ts.URL+"/jobs/1?mode=block&wait=5s", "text/plain", strings.NewReader("started\n")); err == nil {
+			status = resp.StatusCode
+			resp.Body.Close()
+		}
+		sent <- status
+	}()
+	time.Sleep(50 * time.Millisecond)
+	resp, err := http.Get(ts.URL + "/jobs/*")
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	select {
+	case status := <-sent:
+		if status != http.StatusOK {
+			t.Errorf("Invalid status for a block mode sender with a wildcard receiver: %d", status)
+		}
+	case <-time.After(4 * time.Second):
+		t.Fatalf("Block mode sender should be released by a wildcard receiver")
+	}
+
+	// fail mode senders count wildcard receivers too
+	fail, err := http.Post(ts.URL+"/jobs/2?mode=fail", "text/plain", strings.NewReader("started\n"))
+	if err != nil {
+		t.Fatalf("Error sending: %s", err.Error())
+	}
+	fail.Body.Close()
+	if fail.StatusCode != http.StatusOK {
+		t.Errorf("Invalid status for a fail mode sender with a wildcard receiver: %d", fail.StatusCode)
+	}
+}
+
+func TestBridgeHandler(t *testing.T) {
+	s, ts := makeTestServer()
+	defer ts.Close()
+
+	var bodies []io.Closer
+	defer func() {
+		for _, body := range bodies {
+			body.Close()
+		}
+	}()
+	connect := func(key string) *bufio.Reader {
+		resp, err := http.Get(ts.URL + key)
+		if err != nil {
+			t.Fatalf("Error connecting: %s", err.Error())
+		}
+		bodies = append(bodies, resp.Body)
+		return bufio.NewReader(resp.Body)
+	}
+	do := func(method, url string) (int, string) {
+		req, _ := http.NewRequest(method, ts.URL+url, strings.NewReader("ok\nERROR disk full\n"))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Error requesting %s %s: %s", method, url, err.Error())
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode, string(body)
+	}
+
+	if status, _ := do("POST", "/jobs/1/bridge?to=team"); status != http.StatusNotFound {
+		t.Errorf("Expected both pipes to be required: %d", status)
+	}
+	connect("/jobs/1")
+	team := connect("/team?format=interactive")
+
+	status, body := do("POST", "/jobs/1/bridge?to=team&oneway=1&filter=ERROR")
+	if status != http.StatusCreated || body != "jobs/1 -> team (filter: ERROR)\n" {
+		t.Errorf("Invalid bridge: %d %s", status, body)
+	}
+	if _, body := do("GET", "/team/bridge"); body != "jobs/1 -> team (filter: ERROR)\n" {
+		t.Errorf("Invalid bridge list: %s", body)
+	}
+
+	do("POST", "/jobs/1")
+	line, err := readUntil(team, "ERROR")
+	if err != nil || !strings.HasPrefix(line, "[jobs/1] ") || !strings.HasSuffix(line, ": ERROR disk full\n") {
+		t.Errorf("Invalid bridged line: %q %v", line, err)
+	}
+	if strings.Contains(line, "ok") {
+		t.Errorf("Filtered line was bridged: %q", line)
+	}
+
+	// the upload closed the job pipe which removes its bridges (once its receiver has left)
+	for s.allPipes.FindPipe("jobs/1") != nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, body := do("GET", "/team/bridge"); body != "" {
+		t.Errorf("Bridge not removed with the pipe: %s", body)
+	}
+
+	connect("/jobs/2")
+	if status, body := do("POST", "/team/bridge?to=jobs/2"); status != http.StatusCreated || strings.Count(body, "\n") != 2 {
+		t.Errorf("Expected a bridge in both directions: %d %s", status, body)
+	}
+	if status, body := do("DELETE", "/team/bridge?to=jobs/2"); status != http.StatusOK || body != "team and jobs/2 unbridged\n" {
+		t.Errorf("Invalid unbridge: %d %s", status, body)
+	}
+	if status, _ := do("DELETE", "/team/bridge?to=jobs/2"); status != http.StatusNotFound {
+		t.Errorf("Expected no bridge: %d", status)
+	}
+}
+
+func TestReceiverFilter(t *testing.T) {
+	_, ts := makeTestServer()
+	defer ts.Close()
+	url := ts.URL + "/filterkey"
+
+	if resp, _ := http.Get(url + "?grep=("); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected an invalid pattern: %d", resp.StatusCode)
+	}
+
+	received := make(chan string)
+	go func() {
+		resp, err := http.Get(url + "?grep=ERROR&grep-v=ignore")
+		if err != nil {
+			received <- ""
+			return
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		received <- string(body)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	resp, err := http.Post(url, "text/plain", strings.NewReader("ok\nERROR one\nERROR ignore\nok\n"))
+	if err != nil {
+		t.Fatalf("Error sending: %s", err.Error())
+	}
+	resp.Body.Close()
+	if body := <-received; body != "ERROR one\n" {
+		t.Errorf("Invalid filtered data: %q", body)
+	}
+}
+
+func TestRecording(t *testing.T) {
+	s, ts := makeTestServer()
+	defer ts.Close()
+	url := ts.URL + "/incident"
+
+	if resp, _ := http.Get(url + "?record=1"); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected recording to be disabled: %d", resp.StatusCode)
+	}
+	s.recordDir = t.TempDir()
+	if resp, _ := http.Get(url + "/replay"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected no recording: %d", resp.StatusCode)
+	}
+
+	received := make(chan string)
+	go func() {
+		resp, err := http.Get(url + "?record=1")
+		if err != nil {
+			received <- ""
+			return
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		received <- string(body)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	resp, err := http.Post(url, "text/plain", strings.NewReader("first\n"))
+	if err != nil {
+		t.Fatalf("Error sending: %s", err.Error())
+	}
+	resp.Body.Close()
+	if body := <-received; body != "first\n" {
+		t.Errorf("Invalid data: %q", body)
+	}
+
+	resp, err = http.Get(url + "/replay?speed=100")
+	if err != nil {
+		t.Fatalf("Error replaying: %s", err.Error())
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "first\n" {
+		t.Errorf("Invalid replay: %d %q", resp.StatusCode, string(body))
+	}
+	resp, err = http.Get(url + "/replay?format=asciicast")
+	if err != nil {
+		t.Fatalf("Error replaying: %s", err.Error())
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if lines := strings.Split(string(body), "\n"); len(lines) != 3 || !strings.HasSuffix(lines[1], `"o","first\n"]`) {
+		t.Errorf("Invalid asciicast replay: %q", string(body))
+	}
+	if resp, _ := http.Get(url + "/replay?speed=0"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected an invalid speed: %d", resp.StatusCode)
+	}
+
+	// the receivers of a pipe are told when someone starts recording it
+	listener, err := http.Get(ts.URL + "/meeting?interactive=1")
+	if err != nil {
+		t.Fatalf("Error receiving: %s", err.Error())
+	}
+	defer listener.Body.Close()
+	recorder, err := http.Get(ts.URL + "/meeting?record=1&user=bob")
+	if err != nil {
+		t.Fatalf("Error receiving: %s", err.Error())
+	}
+	defer recorder.Body.Close()
+	if line, err := readUntil(bufio.NewReader(listener.Body), "recording"); line != "bob: recording started\n" {
+		t.Errorf("Expected the receivers to be told about the recording: %q %v", line, err)
+	}
+}
+
+func TestTerminalMode(t *testing.T) {
+	s, ts := makeTestServer()
+	defer ts.Close()
+	url := ts.URL + "/session?mode=tty"
+
+	receive := func(accept string) chan string {
+		received := make(chan string)
+		go func() {
+			req, _ := http.NewRequest("GET", url, nil)
+			req.Header.Set("Accept", accept)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				received <- ""
+				return
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			received <- resp.Header.Get("Content-Type") + "\n" + string(body)
+		}()
+		return received
+	}
+	raw := receive("*/*")
+	cast := receive("application/json")
+	time.Sleep(50 * time.Millisecond)
+
+	req, _ := http.NewRequest("PUT", url, strings.NewReader("hello\x1b[8;40;120tworld"))
+	req.Header.Set("X-Terminal-Size", "100x30")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error sending: %s", err.Error())
+	}
+	resp.Body.Close()
+
+	if body := <-raw; body != "text/plain; charset=utf-8\n\x1b[8;30;100thello\x1b[8;40;120tworld" {
+		t.Errorf("Invalid raw terminal: %q", body)
+	}
+	lines := strings.Split(strings.TrimSpace(<-cast), "\n")
+	if len(lines) != 6 || lines[0] != asciicastType || !strings.Contains(lines[1], `"width":80,"height":24`) {
+		t.Fatalf("Invalid asciicast: %q", lines)
+	}
+	for i, expected := range []string{`"r","100x30"]`, `"o","hello"]`, `"r","120x40"]`, `"o","world"]`} {
+		if !strings.HasSuffix(lines[i+2], expected) {
+			t.Errorf("Invalid asciicast event: %s %s", lines[i+2], expected)
+		}
+	}
+
+	req, _ = http.NewRequest("PUT", ts.URL+"/badsize?mode=tty", strings.NewReader("data"))
+	req.Header.Set("X-Terminal-Size", "wide")
+	if resp, _ := http.DefaultClient.Do(req); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected an invalid terminal size: %d", resp.StatusCode)
+	}
+	// the refused upload doesn't leave an empty pipe behind
+	if s.allPipes.FindPipe("badsize") != nil {
+		t.Errorf("Pipe should not be created for an invalid terminal size")
+	}
+}
+
+func TestBroadcastMode(t *testing.T) {
+	_, ts := makeTestServer()
+	defer ts.Close()
+	url := ts.URL + "/news?mode=broadcast"
+
+	// the owner creates the pipe and gets its token
+	owner, ownerWriter := io.Pipe()
+	defer ownerWriter.Close()
+	req, _ := http.NewRequest("PUT", url+"&interactive=1&user=alice", owner)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error creating broadcast: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	token := resp.Header.Get("X-Owner-Token")
+	if len(token) != tokenSize {
+		t.Fatalf("Invalid broadcast token: %q", token)
+	}
+
+	if resp, _ := http.Post(ts.URL+"/news", "text/plain", strings.NewReader("spam\n")); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected other senders to be refused: %d", resp.StatusCode)
+	}
+
+	listener, err := http.Get(ts.URL + "/news?interactive=1")
+	if err != nil {
+		t.Fatalf("Error receiving: %s", err.Error())
+	}
+	defer listener.Body.Close()
+	io.WriteString(ownerWriter, "hello everyone\n")
+	if line, _ := readUntil(bufio.NewReader(listener.Body), "everyone"); line != "alice (owner): hello everyone\n" {
+		t.Errorf("Expected the owner's message to be marked: %q", line)
+	}
+
+	// the owner can send again with the token
+	req, _ = http.NewRequest("POST", url, strings.NewReader("again\n"))
+	req.Header.Set("X-Owner-Token", token)
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected the owner to be allowed to send: %v", err)
+	} else {
+		resp.Body.Close()
+	}
+
+	// a message mode sender that creates a broadcast pipe is given its token too
+	resp, err = http.Post(ts.URL+"/notices?mode=broadcast&msg=1", "text/plain", strings.NewReader("hello\n"))
+	if err != nil {
+		t.Fatalf("Error sending message: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent || len(resp.Header.Get("X-Owner-Token")) != tokenSize {
+		t.Errorf("Expected the message sender to get the token: %d %q", resp.StatusCode, resp.Header.Get("X-Owner-Token"))
+	}
+}
+
+func TestModeration(t *testing.T) {
+	_, ts := makeTestServer()
+	defer ts.Close()
+	url := ts.URL + "/room?interactive=1"
+
+	owner, ownerWriter := io.Pipe()
+	defer ownerWriter.Close()
+	req, _ := http.NewRequest("PUT", url+"&moderate=1&user=alice", owner)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error creating room: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	token := resp.Header.Get("X-Owner-Token")
+
+	bob, err := http.Get(url + "&user=bob")
+	if err != nil {
+		t.Fatalf("Error joining room: %s", err.Error())
+	}
+	defer bob.Body.Close()
+
+	// the owner kicks bob by typing a command
+	io.WriteString(ownerWriter, "/kick bob\n")
+	body, _ := io.ReadAll(bob.Body)
+	if !strings.HasSuffix(string(body), "alice: kicked bob\n") {
+		t.Errorf("Expected bob to be kicked: %q", string(body))
+	}
+	if resp, _ := http.Get(url + "&user=bob"); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected bob to be kept out: %d", resp.StatusCode)
+	}
+
+	if resp, _ := http.Post(ts.URL+"/room/moderate?lock&token=guess", "text/plain", nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected only the owner to moderate: %d", resp.StatusCode)
+	}
+	req, _ = http.NewRequest("POST", ts.URL+"/room/moderate?lock", nil)
+	req.Header.Set("X-Owner-Token", token)
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected the owner to lock the room: %v", err)
+	}
+	if resp, _ := http.Get(url + "&user=carol"); resp.StatusCode != http.StatusLocked {
+		t.Errorf("Expected the room to be locked: %d", resp.StatusCode)
+	}
+}
+
+func TestReceiverKeepAlive(t *testing.T) {
+	s, ts := makeTestServer()
+	defer ts.Close()
+	s.heartbeat = 20 * time.Millisecond
+	s.heartbeatData = []byte("\n")
+
+	// a quiet raw receiver gets heartbeats until its idle timeout
+	resp, err := http.Get(ts.URL + "/quiet?timeout=150ms")
+	if err != nil {
+		t.Fatalf("Error receiving: %s", err.Error())
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if len(body) < 3 || strings.Trim(string(body), "\n") != "" {
+		t.Errorf("Expected heartbeats: %q", string(body))
+	}
+
+	// event source receivers get comment lines
+	resp, err = http.Get(ts.URL + "/quiet?format=sse&timeout=50ms")
+	if err != nil {
+		t.Fatalf("Error receiving: %s", err.Error())
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.HasSuffix(string(body), "\n\n: heartbeat\n\n") {
+		t.Errorf("Expected sse heartbeats: %q", string(body))
+	}
+}
+
+func TestRPCMode(t *testing.T) {
+	s, ts := makeTestServer()
+	defer ts.Close()
+	url := ts.URL + "/worker?mode=rpc"
+
+	if resp, _ := http.Post(url, "text/plain", strings.NewReader("ping")); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected no responders: %d", resp.StatusCode)
+	}
+
+	// a worker answers a single request
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Errorf("Error listening: %s", err.Error())
+			return
+		}
+		request, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		reply := strings.ToUpper(string(request))
+		resp, err = http.Post(resp.Header.Get("X-Reply-Url"), "text/plain", strings.NewReader(reply))
+		if err != nil {
+			t.Errorf("Error replying: %s", err.Error())
+			return
+		}
+		resp.Body.Close()
+	}()
+	for s.rpc.Responders("worker") < 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	resp, err := http.Post(url, "text/plain", strings.NewReader("ping"))
+	if err != nil {
+		t.Fatalf("Error calling: %s", err.Error())
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "PING" || len(resp.Header.Get("X-Request-Id")) != rpcIDSize {
+		t.Errorf("Invalid reply: %d %s", resp.StatusCode, string(body))
+	}
+
+	// a worker that never replies times out
+	s.rpcTimeout = 50 * time.Millisecond
+	go func() {
+		resp, err := http.Get(url)
+		if err == nil {
+			io.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+	}()
+	for s.rpc.Responders("worker") < 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	resp, err = http.Post(url, "text/plain", strings.NewReader("ping"))
+	if err != nil {
+		t.Fatalf("Error calling: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("Expected a timeout: %d", resp.StatusCode)
+	}
+	if resp, _ := http.Post(ts.URL+"/worker/reply/unknown", "text/plain", strings.NewReader("late")); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected an unknown request: %d", resp.StatusCode)
+	}
+}
+
+// sendRaw starts an upload that waits for 100 Continue on a raw connection so that the test sees the exact wire order
+func sendRaw(t *testing.T, ts *httptest.Server, path string, length int) (net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Error connecting: %s", err.Error())
+	}
+	fmt.Fprintf(conn, "PUT %s HTTP/1.1\r\nHost: test\r\nExpect: 100-continue\r\nContent-Length: %d\r\n\r\n", path, length)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return conn, bufio.NewReader(conn)
+}
+
+// readStatus skips to the next status line and returns it
+func readStatus(t *testing.T, reader *bufio.Reader) string {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Error reading response: %s", err.Error())
+		}
+		if strings.HasPrefix(line, "HTTP/") {
+			return strings.TrimSpace(line)
+		}
+	}
+}
+
+func TestExpectContinue(t *testing.T) {
+	s, ts := makeTestServer()
+	defer ts.Close()
+
+	// the 100 Continue always goes out before the response headers
+	for i := 0; i < 20; i++ {
+		func() {
+			conn, reader := sendRaw(t, ts, fmt.Sprintf("/order%d", i), 5)
+			defer conn.Close()
+			if status := readStatus(t, reader); status != "HTTP/1.1 100 Continue" {
+				t.Fatalf("Expected 100 Continue first: %s", status)
+			}
+			if status := readStatus(t, reader); status != "HTTP/1.1 200 OK" {
+				t.Fatalf("Expected the response after 100 Continue: %s", status)
+			}
+			conn.Write([]byte("hello"))
+		}()
+	}
+
+	// a sender that will be refused is told so before it sends its body
+	conn, reader := sendRaw(t, ts, "/nobody?mode=fail", 5)
+	if status := readStatus(t, reader); status != "HTTP/1.1 417 Expectation Failed" {
+		t.Errorf("Expected the sender to be refused without 100 Continue: %s", status)
+	}
+	conn.Close()
+
+	// a blocked sender gets nothing back until a receiver connects
+	conn, reader = sendRaw(t, ts, "/wire?mode=block", 5)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := reader.ReadByte(); err == nil {
+		t.Fatalf("Expected nothing to be sent before a receiver connects")
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	received := make(chan string)
+	go func() {
+		resp, err := http.Get(ts.URL + "/wire")
+		if err != nil {
+			received <- err.Error()
+			return
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		received <- string(body)
+	}()
+	if status := readStatus(t, reader); status != "HTTP/1.1 100 Continue" {
+		t.Fatalf("Expected 100 Continue once a receiver connected: %s", status)
+	}
+	if status := readStatus(t, reader); status != "HTTP/1.1 200 OK" {
+		t.Fatalf("Expected the response after 100 Continue: %s", status)
+	}
+	for s.allPipes.FindPipe("wire").ReceiverCount() < 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	conn.Write([]byte("hello"))
+	if body := <-received; body != "hello" {
+		t.Errorf("Invalid block mode receive: %q", body)
+	}
+}
+
+func TestInvalidParams(t *testing.T) {
+	_, ts := makeTestServer()
+	defer ts.Close()
+
+	queries := []string{"wait=abc", "wait=-5s", "timeout=abc", "heartbeat=0s", "idle=abc", "maxmb=1.5", "pipemb=-1", "rate=fast"}
+	for _, query := range queries {
+		resp, err := http.Get(ts.URL + "/durations?" + query)
+		if err != nil {
+			t.Fatalf("Error connecting: %s", err.Error())
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest || !strings.HasPrefix(string(body), "Invalid ") {
+			t.Errorf("Expected %s to be refused: %d %s", query, resp.StatusCode, string(body))
+		}
+	}
+}
+
+func TestBlockModeWait(t *testing.T) {
+	_, ts := makeTestServer()
+	defer ts.Close()
+
+	// no receiver connects within the wait - the body is never asked for
+	conn, reader := sendRaw(t, ts, "/lonely?mode=block&wait=50ms", 5)
+	defer conn.Close()
+	if status := readStatus(t, reader); status != "HTTP/1.1 504 Gateway Timeout" {
+		t.Errorf("Expected a timeout without 100 Continue: %s", status)
+	}
+
+	// the sender left first
+	pipe := MakePipe(&TestHandler{})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := httptest.NewRequest("PUT", "/gone", nil).WithContext(ctx)
+	err := waitForReceiver(r, pipe, time.Hour)
+	if err != context.Canceled {
+		t.Errorf("Expected the sender to be gone: %v", err)
+	}
+	w := httptest.NewRecorder()
+	receiverWaitError(w, err, time.Hour)
+	if w.Code != statusClientClosed {
+		t.Errorf("Invalid status for a sender that left: %d", w.Code)
+	}
+
+	if err := waitForReceiver(httptest.NewRequest("PUT", "/late", nil), pipe, 10*time.Millisecond); err != ErrNoReceiver {
+		t.Errorf("Expected no receiver: %v", err)
+	}
+}
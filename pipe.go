@@ -1,24 +1,79 @@
 package main
 
 import (
+	"crypto/subtle"
 	"fmt"
+	"io"
+	"sync"
 )
 
 // Pipe holds the information for a single pipe
 type Pipe struct {
+	// guards the state of the pipe - it is shared by the handlers of all of its senders and receivers
+	// receivers are written to outside of the lock so one slow receiver doesn't hold up the pipe
+	mu sync.Mutex
+	// the key of the pipe (a pattern like team/builds/* for wildcard subscriptions)
+	key string
 	// a list of receivers that are listening on a pipe
 	// allow receivers to be added an removed dynamically
 	receivers map[RecieveWriter]bool
 	senders   int
 	bytes     int
 	written   WriteCompleteHandler
+	// receivers on their way to being added (the pipe isn't deleted while there are any)
+	joining int
+	// the number of receivers ever added (senders use it to notice new receivers)
+	joined int
 	// a list of channels that want to be notified of new receivers
 	receiverAdded map[chan bool]bool
+	// the description of the data supplied by the first sender (nil until then)
+	file *FileInfo
+	// a list of channels that want to be notified when the file description is set
+	fileAdded map[chan bool]bool
+	// the state of a one-shot file transfer (nil unless the pipe is in file mode)
+	transfer *FileTransfer
+	// restrictions on the data sent through the pipe
+	limits Limits
+	// recent messages sent to new receivers (nil unless the owner of a reserved key asked for it)
+	replay *ReplayBuffer
+	// the number of receivers allowed at once (0 for unlimited)
+	maxReceivers int
+	// finds the wildcard pipes that also get the messages written to this pipe (nil for none)
+	subscriptions Subscriptions
+	// other pipes that get everything written to this pipe
+	bridges map[*Bridge]bool
+	// keeps everything written to the pipe (nil unless ?record=1 was used)
+	recorder *Recorder
+	// the size of the terminal shared on the pipe (nil until a tty sender sets it)
+	size *TerminalSize
+	// the token of the owner of the pipe ("" if the pipe has no owner)
+	owner string
+	// only the owner can send to a broadcast pipe
+	broadcast bool
+	// the users the owner has kicked or muted and whether the pipe is locked
+	moderation Moderation
+}
+
+// Subscriptions finds the wildcard pipes subscribed to a key
+// it is implemented by PipeCollection
+type Subscriptions interface {
+	Subscribers(key string) []*Pipe
 }
 
 // AddReceiver adds a new receiver listening on the pipe
 func (p *Pipe) AddReceiver(w RecieveWriter) {
+	p.mu.Lock()
+	replay := p.replay
+	p.mu.Unlock()
+	if replay != nil {
+		for _, m := range replay.Messages() {
+			w.Write(m.Format(w))
+		}
+	}
+	p.mu.Lock()
 	p.receivers[w] = true
+	p.joined++
+	p.mu.Unlock()
 	p.Write(Message{
 		fromID:   w.ID(),
 		fromUser: w.Username(),
@@ -29,7 +84,9 @@ func (p *Pipe) AddReceiver(w RecieveWriter) {
 
 // RemoveReceiver removes a previously added receiver
 func (p *Pipe) RemoveReceiver(w RecieveWriter) {
+	p.mu.Lock()
 	delete(p.receivers, w)
+	p.mu.Unlock()
 	p.Write(Message{
 		fromID:   w.ID(),
 		fromUser: w.Username(),
@@ -37,13 +94,168 @@ func (p *Pipe) RemoveReceiver(w RecieveWriter) {
 		system:   true})
 }
 
+// Receivers returns the receivers currently listening on the pipe
+func (p *Pipe) Receivers() []RecieveWriter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	receivers := make([]RecieveWriter, 0, len(p.receivers))
+	for receiver := range p.receivers {
+		receivers = append(receivers, receiver)
+	}
+	return receivers
+}
+
+// Joined returns the number of receivers that have ever been added to the pipe
+func (p *Pipe) Joined() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.joined
+}
+
 // ReceiverCount returns the number of receivers on the pipe
-func (p Pipe) ReceiverCount() int {
+func (p *Pipe) ReceiverCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	return len(p.receivers)
 }
 
+// ListenerCount returns the number of receivers on the pipe and on the wildcard pipes subscribed to it
+// this is what fail and block mode senders check since messages reach all of them
+// a file is only sent to the receiver of its own pipe so subscribers aren't counted for file pipes
+func (p *Pipe) ListenerCount() int {
+	count := p.ReceiverCount()
+	if p.subscriptions != nil && p.Transfer() == nil {
+		for _, subscription := range p.subscriptions.Subscribers(p.key) {
+			count += subscription.ReceiverCount()
+		}
+	}
+	return count
+}
+
+// TerminalSize returns the size of the terminal shared on the pipe
+func (p *Pipe) TerminalSize() TerminalSize {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.size == nil {
+		return defaultTerminalSize
+	}
+	return *p.size
+}
+
+// SetOwner gives the holder of the token control of the pipe
+// only the owner can send to a broadcast pipe
+func (p *Pipe) SetOwner(token string, broadcast bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.owner = token
+	p.broadcast = broadcast
+}
+
+// Broadcast returns whether only the owner can send to the pipe
+func (p *Pipe) Broadcast() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.broadcast
+}
+
+// IsOwner returns whether the token belongs to the owner of the pipe
+func (p *Pipe) IsOwner(token string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.isOwner(token)
+}
+
+func (p *Pipe) isOwner(token string) bool {
+	return len(p.owner) > 0 && subtle.ConstantTimeCompare([]byte(p.owner), []byte(token)) == 1
+}
+
+// Admit checks whether a new connection can join the pipe - the owner can always join
+func (p *Pipe) Admit(username, token string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.isOwner(token) {
+		return nil
+	}
+	if p.moderation.kicked[username] {
+		return ErrKicked
+	}
+	if p.moderation.locked {
+		return ErrLocked
+	}
+	return nil
+}
+
+// Kicked returns whether the owner removed a user from the pipe
+func (p *Pipe) Kicked(username string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.moderation.kicked[username]
+}
+
+// Muted returns whether the owner muted a user
+func (p *Pipe) Muted(username string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.moderation.muted[username]
+}
+
+// Moderate applies an action from the owner and announces it to everyone on the pipe
+// a kicked user is disconnected
+func (p *Pipe) Moderate(action ModerationAction, fromID int, fromUser string) {
+	p.mu.Lock()
+	p.moderation.apply(action)
+	p.mu.Unlock()
+	p.Write(Message{
+		fromID:   fromID,
+		fromUser: fromUser,
+		buffer:   []byte(action.String() + "\n"),
+		system:   true})
+	if action.Action != "kick" {
+		return
+	}
+	for _, receiver := range p.Receivers() {
+		if receiver.Username() == action.User {
+			receiver.Close()
+		}
+	}
+}
+
+// tell writes a message to the receivers of a single connection only
+func (p *Pipe) tell(id int, m Message) {
+	for _, receiver := range p.Receivers() {
+		if receiver.ID() == id {
+			receiver.Write(m.Format(receiver))
+		}
+	}
+}
+
+// Full returns whether the pipe has as many receivers as it allows
+// senders are also receivers (to get replies) so they aren't counted
+func (p *Pipe) Full() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.maxReceivers > 0 && len(p.receivers)-p.senders >= p.maxReceivers
+}
+
+// SetDefaults applies the settings chosen by the owner of a reserved key
+func (p *Pipe) SetDefaults(d PipeDefaults) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.maxReceivers = d.MaxReceivers
+	switch {
+	case d.Replay < 1:
+		p.replay = nil
+	case p.replay == nil:
+		p.replay = MakeReplayBuffer(d.Replay)
+	default:
+		p.replay.Resize(d.Replay)
+	}
+}
+
 // ReceiverAddedSubscribe listens for new receivers
 func (p *Pipe) ReceiverAddedSubscribe() chan bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	channel := make(chan bool)
 	p.receiverAdded[channel] = true
 	return channel
@@ -51,11 +263,15 @@ func (p *Pipe) ReceiverAddedSubscribe() chan bool {
 
 // ReceiverAddedUnSubscribe stops listening for new receivers
 func (p *Pipe) ReceiverAddedUnSubscribe(channel chan bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	delete(p.receiverAdded, channel)
 }
 
 // ReceiverAddedNotify notifies all listeners that a receiver was added
 func (p *Pipe) ReceiverAddedNotify() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	for channel := range p.receiverAdded {
 		// non-blocking
 		select {
@@ -67,51 +283,245 @@ func (p *Pipe) ReceiverAddedNotify() {
 
 // AddSender adds a new sender connected to send data on the pipe (informational)
 func (p *Pipe) AddSender() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	p.senders++
 }
 
 // RemoveSender removes a sender connected to the pipe (informational)
 func (p *Pipe) RemoveSender() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	p.senders--
 }
 
+// SetFileInfo describes the data being sent on the pipe - only the first sender is used
+func (p *Pipe) SetFileInfo(info FileInfo) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.file != nil {
+		return
+	}
+	p.file = &info
+	for channel := range p.fileAdded {
+		// non-blocking
+		select {
+		case channel <- true:
+		default:
+		}
+	}
+}
+
+// FileInfo returns the description of the data being sent or nil if no sender has connected
+func (p *Pipe) FileInfo() *FileInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.file
+}
+
+// FileInfoSubscribe listens for a sender to describe the data on the pipe
+func (p *Pipe) FileInfoSubscribe() chan bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	channel := make(chan bool, 1)
+	p.fileAdded[channel] = true
+	return channel
+}
+
+// FileInfoUnSubscribe stops listening for the file description
+func (p *Pipe) FileInfoUnSubscribe(channel chan bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.fileAdded, channel)
+}
+
+// Limits returns the restrictions on data sent through the pipe
+func (p *Pipe) Limits() Limits {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.limits
+}
+
+// Transfer returns the one-shot file transfer state or nil if the pipe is not in file mode
+func (p *Pipe) Transfer() *FileTransfer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.transfer
+}
+
 // SenderCount returns the number of senders on the pipe
-func (p Pipe) SenderCount() int {
+func (p *Pipe) SenderCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	return p.senders
 }
 
 // BytesSent returns the number of bytes sent through the pipe
-func (p Pipe) BytesSent() int {
+func (p *Pipe) BytesSent() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	return p.bytes
 }
 
-// Write the buffer to all registered receivers
+// Write the buffer to all registered receivers and any wildcard subscriptions
 func (p *Pipe) Write(m Message) (int, error) {
-	for receiver := range p.receivers {
-		receiver.Write(m.Format(receiver))
+	if len(m.key) == 0 {
+		m.key = p.key
+	}
+	p.mu.Lock()
+	if m.size != nil {
+		p.size = m.size
+	}
+	recorder, replay := p.recorder, p.replay
+	bridges := make([]*Bridge, 0, len(p.bridges))
+	for bridge := range p.bridges {
+		bridges = append(bridges, bridge)
 	}
+	p.mu.Unlock()
+
+	if recorder != nil {
+		recorder.Record(m)
+	}
+	p.deliver(m)
 	bytes := len(m.buffer)
 	if !m.system {
-		p.bytes += bytes
-		p.written.WriteCompleted(bytes)
+		p.AddBytes(bytes)
+		if replay != nil {
+			replay.Add(m)
+		}
+	}
+	if p.subscriptions != nil {
+		for _, subscription := range p.subscriptions.Subscribers(p.key) {
+			subscription.deliver(m)
+		}
+	}
+	for _, bridge := range bridges {
+		bridge.Forward(m)
 	}
 	return bytes, nil
 }
 
+// deliver a message to the receivers of this pipe only
+// messages from another pipe are marked so formatters can say where they came from
+func (p *Pipe) deliver(m Message) {
+	m.forwarded = m.key != p.key
+	for _, receiver := range p.Receivers() {
+		// once a write to a receiver fails (e.g. a half-open connection) the rest are skipped
+		// until its handler notices and removes it
+		receiver.Write(m.Format(receiver))
+	}
+}
+
+// countingWriter counts data written to another destination as sent through the pipe
+type countingWriter struct {
+	pipe   *Pipe
+	writer io.Writer
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.writer.Write(p)
+	c.pipe.AddBytes(n)
+	return n, err
+}
+
+// AddBytes counts data that was sent through the pipe
+func (p *Pipe) AddBytes(bytes int) {
+	p.mu.Lock()
+	p.bytes += bytes
+	p.mu.Unlock()
+	p.written.WriteCompleted(bytes)
+}
+
 // Close all of the registered receivers
 func (p *Pipe) Close() error {
-	for receiver := range p.receivers {
+	for _, receiver := range p.Receivers() {
 		// errors from one of the receivers shouldn't affect any others
 		receiver.Close()
 	}
 	return nil
 }
 
-func (p Pipe) String() string {
+func (p *Pipe) String() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	return fmt.Sprintf("%d receivers | %d senders | %d bytes\n",
-		p.ReceiverCount(),
-		p.SenderCount(),
-		p.BytesSent())
+		len(p.receivers),
+		p.senders,
+		p.bytes)
+}
+
+// addJoining holds the pipe open for a receiver that is about to be added (or lets it go again)
+func (p *Pipe) addJoining(delta int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.joining += delta
+}
+
+// empty returns whether nothing is using the pipe anymore
+func (p *Pipe) empty() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.receivers) < 1 && p.senders < 1 && p.joining < 1 && !p.transfer.Claimed() && !p.transfer.Pending()
+}
+
+// StartRecording keeps everything written to the pipe from now on - a pipe is only recorded once
+// returns whether this call started the recording
+func (p *Pipe) StartRecording(open func() (*Recorder, error)) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.recorder != nil {
+		return false, nil
+	}
+	recorder, err := open()
+	if err != nil {
+		return false, err
+	}
+	p.recorder = recorder
+	return true, nil
+}
+
+// stopRecording closes the recording of the pipe if there is one
+func (p *Pipe) stopRecording() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.recorder != nil {
+		p.recorder.Close()
+		p.recorder = nil
+	}
+}
+
+// claimTransfer reserves a slot of the one-shot file transfer on the pipe
+// the transfer is started unless the pipe is already being used in another mode
+func (p *Pipe) claimTransfer(sender bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.transfer == nil {
+		if len(p.receivers) > 0 || p.senders > 0 {
+			return ErrSlotTaken
+		}
+		p.transfer = MakeFileTransfer()
+	}
+	return p.transfer.Claim(sender)
+}
+
+// resetTransfer frees the key of a file transfer that never got any data
+func (p *Pipe) resetTransfer() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.transfer = nil
+	p.file = nil
+}
+
+func (p *Pipe) addBridge(bridge *Bridge) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.bridges[bridge] = true
+}
+
+func (p *Pipe) removeBridge(bridge *Bridge) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.bridges, bridge)
 }
 
 // MakePipe creates the struct for a pipe
@@ -122,5 +532,8 @@ func MakePipe(written WriteCompleteHandler) *Pipe {
 		bytes:         0,
 		written:       written,
 		receiverAdded: make(map[chan bool]bool),
+		fileAdded:     make(map[chan bool]bool),
+		bridges:       make(map[*Bridge]bool),
+		moderation:    MakeModeration(),
 	}
 }
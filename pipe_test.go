@@ -14,8 +14,8 @@ func (r TestReceiver) ID() int {
 	return 0
 }
 
-func (r TestReceiver) Interactive() bool {
-	return false
+func (r TestReceiver) Formatter() Formatter {
+	return RawFormatter{}
 }
 
 func (r TestReceiver) Username() string {
@@ -95,3 +95,24 @@ func TestPipeReceivers(t *testing.T) {
 		t.Errorf("Invalid receiver count: %d %d", 1, pipe.ReceiverCount())
 	}
 }
+
+func TestPipeFileInfo(t *testing.T) {
+	handler := &TestHandler{}
+	pipe := MakePipe(handler)
+	if pipe.FileInfo() != nil {
+		t.Errorf("File info set before any sender")
+	}
+
+	fileAdded := pipe.FileInfoSubscribe()
+	pipe.SetFileInfo(FileInfo{Filename: "first.txt"})
+	pipe.SetFileInfo(FileInfo{Filename: "second.txt"})
+
+	select {
+	case <-fileAdded:
+	default:
+		t.Errorf("File info subscriber not notified")
+	}
+	if pipe.FileInfo().Filename != "first.txt" {
+		t.Errorf("Invalid file info: %s %s", "first.txt", pipe.FileInfo().Filename)
+	}
+}
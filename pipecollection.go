@@ -1,19 +1,41 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"regexp"
 	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNoPipe is returned when bridging a pipe that doesn't exist
+	ErrNoPipe = errors.New("pipe doesn't exist")
+	// ErrNotOwner is returned when someone other than the owner sends to a broadcast pipe
+	ErrNotOwner = errors.New("only the owner can send to a broadcast pipe")
 )
 
 // PipeCollection is a map of pipes partitioned by a key
 type PipeCollection struct {
+	// (writes find wildcard subscriptions and count statistics through the collection)
+	// (writes count statistics through the collection)
+	mu *sync.Mutex
 	// pipe key -> Pipe
-	pipes map[string]*Pipe
+	pipes *pipeTrie
 	stats *PipeStats
+	// keys of one-shot file pipes that have been used and when (they can't be reused until retiredTimeout)
+	retired map[string]time.Time
+	// the default restrictions on new pipes (set by the operator)
+	limits Limits
+	// the bridges between pipes - removed when either pipe is deleted
+	bridges map[*Bridge]bool
 }
 
 // WriteCompleted is a called by the individual pipes to collect statistics
 func (pc *PipeCollection) WriteCompleted(bytes int) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
 	pc.stats.BytesSent += bytes
 }
 
@@ -24,34 +46,252 @@ type WriteCompleteHandler interface {
 
 // FindOrCreatePipe finds a pipe or creates one if it doesn't exist
 func (pc *PipeCollection) FindOrCreatePipe(key string) *Pipe {
-	pipe, exists := pc.pipes[key]
-	if !exists {
-		pipe = MakePipe(pc)
-		pc.pipes[key] = pipe
-		pc.stats.PipeCount++
-	}
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pipe, _ := pc.findOrCreatePipe(key, pc.limits)
 	return pipe
 }
 
+func (pc *PipeCollection) findOrCreatePipe(key string, limits Limits) (*Pipe, bool) {
+	pipe := pc.pipes.Get(key)
+	if pipe != nil {
+		return pipe, false
+	}
+	pipe = MakePipe(pc)
+	pipe.key = key
+	pipe.subscriptions = pc
+	pipe.limits = limits
+	pc.pipes.Put(key, pipe)
+	pc.stats.PipeCount++
+	return pipe, true
+}
+
+// Subscribers returns the wildcard pipes subscribed to a key
+func (pc PipeCollection) Subscribers(key string) []*Pipe {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return pc.pipes.Match(key)
+}
+
+// CreatePipe creates the pipe if it doesn't exist and returns whether it was created
+// the creator of a pipe can lower the default limits for everyone using it
+func (pc *PipeCollection) CreatePipe(key string, requested Limits) (*Pipe, bool) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return pc.findOrCreatePipe(key, pc.limits.Lower(requested))
+}
+
+// SetLimits sets the default restrictions on new pipes
+func (pc *PipeCollection) SetLimits(limits Limits) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.limits = limits
+}
+
+// Limits returns the default restrictions on new pipes
+func (pc PipeCollection) Limits() Limits {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return pc.limits
+}
+
 // DeletePipeIfEmpty deletes the pipe if it has no attached receivers
 func (pc *PipeCollection) DeletePipeIfEmpty(key string, pipe *Pipe) {
-	if pipe.ReceiverCount() < 1 && pipe.SenderCount() < 1 {
-		delete(pc.pipes, key)
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.deletePipeIfEmpty(key, pipe)
+}
+
+func (pc *PipeCollection) deletePipeIfEmpty(key string, pipe *Pipe) {
+	// the key may already belong to a new pipe
+	if pc.pipes.Get(key) == pipe && pipe.empty() {
+		pc.pipes.Delete(key)
+		pipe.stopRecording()
+		for bridge := range pc.bridges {
+			if bridge.from == pipe || bridge.to == pipe {
+				pc.removeBridge(bridge)
+			}
+		}
+	}
+}
+
+// FindPipe returns the pipe for a key or nil if it doesn't exist
+func (pc PipeCollection) FindPipe(key string) *Pipe {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return pc.pipes.Get(key)
+}
+
+// AddBridge forwards everything written to one pipe into another
+// both pipes must exist and a bridge between them replaces any earlier one
+func (pc *PipeCollection) AddBridge(fromKey, toKey string, filter *regexp.Regexp) (*Bridge, error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	from, to := pc.pipes.Get(fromKey), pc.pipes.Get(toKey)
+	if from == nil || to == nil {
+		return nil, ErrNoPipe
+	}
+	for bridge := range pc.bridges {
+		if bridge.from == from && bridge.to == to {
+			pc.removeBridge(bridge)
+		}
+	}
+	bridge := &Bridge{from: from, to: to, fromKey: fromKey, toKey: toKey, filter: filter}
+	from.addBridge(bridge)
+	pc.bridges[bridge] = true
+	pc.stats.BridgeCount++
+	return bridge, nil
+}
+
+// RemoveBridges removes the bridges between two pipes in both directions
+// returns the number of bridges removed
+func (pc *PipeCollection) RemoveBridges(key, otherKey string) int {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	removed := 0
+	for bridge := range pc.bridges {
+		if (bridge.fromKey == key && bridge.toKey == otherKey) || (bridge.fromKey == otherKey && bridge.toKey == key) {
+			pc.removeBridge(bridge)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Bridges returns the bridges to and from a pipe
+func (pc PipeCollection) Bridges(key string) []*Bridge {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	var bridges []*Bridge
+	for bridge := range pc.bridges {
+		if bridge.fromKey == key || bridge.toKey == key {
+			bridges = append(bridges, bridge)
+		}
+	}
+	return bridges
+}
+
+func (pc *PipeCollection) removeBridge(bridge *Bridge) {
+	bridge.from.removeBridge(bridge)
+	delete(pc.bridges, bridge)
+}
+
+// IsFilePipe returns whether the key is currently in use by a one-shot file transfer
+func (pc *PipeCollection) IsFilePipe(key string) bool {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pipe := pc.pipes.Get(key)
+	return pipe != nil && pipe.Transfer() != nil
+}
+
+// IsRetired returns whether the key was used for a one-shot file transfer
+func (pc *PipeCollection) IsRetired(key string) bool {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return pc.isRetired(key)
+}
+
+func (pc *PipeCollection) isRetired(key string) bool {
+	retired, exists := pc.retired[key]
+	return exists && time.Since(retired) <= retiredTimeout
+}
+
+// ClaimFile reserves the sender or receiver slot of a one-shot file pipe - creates the pipe if it doesn't exist
+func (pc *PipeCollection) ClaimFile(key string, sender bool) (*Pipe, error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.expireFiles(time.Now())
+	if pc.isRetired(key) {
+		return nil, ErrKeyRetired
+	}
+	pipe, _ := pc.findOrCreatePipe(key, pc.limits)
+	if err := pipe.claimTransfer(sender); err != nil {
+		return nil, err
+	}
+	return pipe, nil
+}
+
+// ReleaseFile frees a slot of a one-shot file pipe
+// retires the key if the file was delivered and frees the key if nothing was uploaded
+func (pc *PipeCollection) ReleaseFile(key string, pipe *Pipe, sender bool) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	transfer := pipe.Transfer()
+	transfer.Release(sender)
+	if transfer.Complete() {
+		pc.retired[key] = time.Now()
+		transfer.Discard()
+	} else if !transfer.Claimed() && (transfer.Spool() == nil || transfer.Spool().Size() < 1) {
+		transfer.Discard()
+		pipe.resetTransfer()
+	}
+	pc.deletePipeIfEmpty(key, pipe)
+}
+
+// ExpireFiles retires the keys of incomplete file transfers that haven't been resumed in time
+// and frees the keys that have been retired for long enough
+func (pc *PipeCollection) ExpireFiles(now time.Time) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.expireFiles(now)
+}
+
+func (pc *PipeCollection) expireFiles(now time.Time) {
+	for key, retired := range pc.retired {
+		if now.Sub(retired) > retiredTimeout {
+			delete(pc.retired, key)
+		}
+	}
+	var expired []string
+	pc.pipes.Walk(func(key string, pipe *Pipe) {
+		if transfer := pipe.Transfer(); transfer != nil && transfer.Expired(now) {
+			expired = append(expired, key)
+		}
+	})
+	for _, key := range expired {
+		pc.pipes.Get(key).Transfer().Discard()
+		pc.retired[key] = now
+		pc.pipes.Delete(key)
 	}
 }
 
 // AddReceiver adds a new receiver to a pipe - creates the pipe if it doesn't exist
 func (pc *PipeCollection) AddReceiver(key string, receiver RecieveWriter) *Pipe {
-	pipe := pc.FindOrCreatePipe(key)
-	pipe.AddReceiver(receiver)
+	pc.mu.Lock()
+	pipe, _ := pc.findOrCreatePipe(key, pc.limits)
+	pipe.addJoining(1)
 	pc.stats.ReceiverCount++
+	pc.mu.Unlock()
+
+	// adding the receiver writes to the pipe so it has to happen outside of the lock
+	pipe.AddReceiver(receiver)
+	pipe.addJoining(-1)
+	// block mode senders on the pipes a wildcard receiver subscribes to are waiting for it too
+	if isPattern(key) {
+		for _, subscribed := range pc.subscribedTo(key) {
+			subscribed.ReceiverAddedNotify()
+		}
+	}
 	return pipe
 }
 
+// subscribedTo returns the pipes (other than file pipes) with a key that matches a wildcard pattern
+func (pc *PipeCollection) subscribedTo(pattern string) []*Pipe {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	var pipes []*Pipe
+	pc.pipes.Walk(func(key string, pipe *Pipe) {
+		if key != pattern && pipe.Transfer() == nil && matchesPattern(pattern, key) {
+			pipes = append(pipes, pipe)
+		}
+	})
+	return pipes
+}
+
 // RemoveReceiver removes a receiver from a pipe - removes the pipe if its empty
 func (pc *PipeCollection) RemoveReceiver(key string, receiver RecieveWriter) {
-	pipe, exists := pc.pipes[key]
-	if !exists {
+	pipe := pc.FindPipe(key)
+	if pipe == nil {
 		return
 	}
 	pipe.RemoveReceiver(receiver)
@@ -59,11 +299,17 @@ func (pc *PipeCollection) RemoveReceiver(key string, receiver RecieveWriter) {
 }
 
 // AddSender adds a new sender to a pipe - creates the pipe if it doesn't exist
-func (pc *PipeCollection) AddSender(key string) *Pipe {
-	pipe := pc.FindOrCreatePipe(key)
+// only the owner (the holder of the token) can send to a broadcast pipe
+func (pc *PipeCollection) AddSender(key, token string) (*Pipe, error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pipe, _ := pc.findOrCreatePipe(key, pc.limits)
+	if pipe.Broadcast() && !pipe.IsOwner(token) {
+		return nil, ErrNotOwner
+	}
 	pipe.AddSender()
 	pc.stats.SenderCount++
-	return pipe
+	return pipe, nil
 }
 
 // RemoveSender removes a sender from the pipe - remove the pipe if its empty
@@ -77,6 +323,7 @@ type PipeStats struct {
 	PipeCount     int
 	ReceiverCount int
 	SenderCount   int
+	BridgeCount   int
 	BytesSent     int
 }
 
@@ -87,27 +334,34 @@ func (ps PipeStats) MegaBytesSent() int {
 
 // ActiveStats returns the statistics for only connected pipes in the collection
 func (pc PipeCollection) ActiveStats() PipeStats {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
 	stats := PipeStats{}
-	for _, pipe := range pc.pipes {
+	pc.pipes.Walk(func(key string, pipe *Pipe) {
 		stats.PipeCount++
 		stats.ReceiverCount += pipe.ReceiverCount()
 		stats.SenderCount += pipe.SenderCount()
 		stats.BytesSent += pipe.BytesSent()
-	}
+	})
+	stats.BridgeCount = len(pc.bridges)
 	return stats
 }
 
 // GlobalStats returns the statistics for all pipes ever to exist in the collection
 func (pc PipeCollection) GlobalStats() PipeStats {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
 	return *pc.stats
 }
 
 func (pc PipeCollection) String() string {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
 	var sb strings.Builder
-	sb.WriteString(fmt.Sprintf("%d keys\n", len(pc.pipes)))
-	for key, pipe := range pc.pipes {
+	sb.WriteString(fmt.Sprintf("%d keys\n", pc.pipes.Len()))
+	pc.pipes.Walk(func(key string, pipe *Pipe) {
 		sb.WriteString(fmt.Sprintf("%s: %s", key, pipe.String()))
-	}
+	})
 	return sb.String()
 }
 
@@ -115,7 +369,10 @@ func (pc PipeCollection) String() string {
 func MakePipeCollection() PipeCollection {
 	stats := PipeStats{}
 	return PipeCollection{
-		pipes: make(map[string]*Pipe),
-		stats: &stats,
+		mu:      &sync.Mutex{},
+		pipes:   makePipeTrie(),
+		stats:   &stats,
+		retired: make(map[string]time.Time),
+		bridges: make(map[*Bridge]bool),
 	}
 }
@@ -1,13 +1,17 @@
 package main
 
-import "testing"
+import (
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
 
 func TestPipeCollectionWrite(t *testing.T) {
 	pipes := MakePipeCollection()
 	receiver := &TestReceiver{}
 	pipes.AddReceiver("key", receiver)
 
-	pipe := pipes.AddSender("key")
+	pipe, _ := pipes.AddSender("key", "")
 	sender := MakeSender(pipe, 1, "")
 	input := "test input"
 	sender.Write([]byte(input))
@@ -21,12 +25,12 @@ func TestMultipleReadersAndWriters(t *testing.T) {
 	input := "test input"
 	pipes := MakePipeCollection()
 
-	p1 := pipes.AddSender("key1")
+	p1, _ := pipes.AddSender("key1", "")
 	s1 := MakeSender(p1, 1, "")
 	r1 := &TestReceiver{}
 	pipes.AddReceiver("key1", r1)
 
-	p2 := pipes.AddSender("key1")
+	p2, _ := pipes.AddSender("key1", "")
 	s2 := MakeSender(p2, 1, "")
 	r2 := &TestReceiver{}
 	pipes.AddReceiver("key1", r2)
@@ -45,19 +49,19 @@ func TestMultipleReadersAndWriters(t *testing.T) {
 func TestCollectionAddRemove(t *testing.T) {
 	pipes := MakePipeCollection()
 
-	pipes.AddSender("key1")
+	pipes.AddSender("key1", "")
 
 	r1 := &TestReceiver{}
 	pipes.AddReceiver("key1", r1)
 	pipes.RemoveReceiver("key1", r1)
 
-	p2 := pipes.AddSender("key2")
+	p2, _ := pipes.AddSender("key2", "")
 	pipes.RemoveSender("key2", p2)
 
 	r2 := &TestReceiver{}
 	pipes.AddReceiver("key2", r2)
 
-	p3 := pipes.AddSender("key2")
+	p3, _ := pipes.AddSender("key2", "")
 	pipes.RemoveSender("key2", p3)
 
 	r3 := &TestReceiver{}
@@ -78,3 +82,57 @@ func TestCollectionAddRemove(t *testing.T) {
 		t.Errorf("Invalid receiver count: %d", stats.ReceiverCount)
 	}
 }
+
+// receivers come and go while a sender writes (run with -race)
+func TestCollectionConcurrent(t *testing.T) {
+	pipes := MakePipeCollection()
+	pipe, _ := pipes.AddSender("key", "")
+	sender := MakeSender(pipe, 1, "")
+	written := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			sender.Write([]byte("test input\n"))
+		}
+		close(written)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			recorder := httptest.NewRecorder()
+			receiver := MakeReceiver(recorder, recorder, id, RawFormatter{}, "")
+			pipes.AddReceiver("key", receiver)
+			pipes.ActiveStats()
+			pipes.RemoveReceiver("key", receiver)
+		}(i + 2)
+	}
+	wg.Wait()
+	<-written
+	pipes.RemoveSender("key", pipe)
+
+	if pipes.FindPipe("key") != nil {
+		t.Error("Pipe not deleted after everyone left")
+	}
+	if stats := pipes.GlobalStats(); stats.ReceiverCount != 10 || stats.BytesSent != 1100 {
+		t.Errorf("Invalid stats: %+v", stats)
+	}
+}
+
+func TestBroadcastSender(t *testing.T) {
+	pipes := MakePipeCollection()
+	pipes.AddReceiver("news", &TestReceiver{})
+	pipes.FindPipe("news").SetOwner("token", true)
+
+	if _, err := pipes.AddSender("news", ""); err != ErrNotOwner {
+		t.Errorf("Expected a sender without a token to be refused: %v", err)
+	}
+	if _, err := pipes.AddSender("news", "guess"); err != ErrNotOwner {
+		t.Errorf("Expected a sender with the wrong token to be refused: %v", err)
+	}
+	pipe, err := pipes.AddSender("news", "token")
+	if err != nil || pipe.SenderCount() != 1 {
+		t.Errorf("Expected the owner to be allowed: %v", err)
+	}
+}
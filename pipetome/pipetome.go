@@ -0,0 +1,353 @@
+// Package pipetome is a client for pipe-to-me servers (https://pipeto.me)
+//
+// Data sent to a pipe url with Send is streamed to every Receive on the same url.
+// Dial opens a connection that both sends and receives, which is how chat works in interactive mode.
+package pipetome
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Mode changes how the server handles a connection (see the server's home page)
+type Mode string
+
+// The modes supported by the server
+const (
+	ModeDefault     Mode = ""
+	ModeFail        Mode = "fail"
+	ModeBlock       Mode = "block"
+	ModeInteractive Mode = "interactive"
+	ModeFile        Mode = "file"
+)
+
+var (
+	// ErrNoReceivers is returned when sending in fail mode and no receivers are connected
+	ErrNoReceivers = errors.New("pipetome: no receivers connected")
+	// ErrNoSenders is returned when receiving in fail mode and no senders are connected
+	ErrNoSenders = errors.New("pipetome: no senders connected")
+)
+
+// StatusError is returned when the server responds with an unexpected status code
+type StatusError struct {
+	StatusCode int
+	Message    string
+	err        error
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("pipetome: %d %s", e.StatusCode, e.Message)
+}
+
+// Unwrap returns ErrNoReceivers or ErrNoSenders for fail mode responses
+func (e *StatusError) Unwrap() error {
+	return e.err
+}
+
+type options struct {
+	mode       Mode
+	username   string
+	password   string
+	retries    int
+	retryDelay time.Duration
+	client     *http.Client
+	// the size of the data sent with Send (-1 to use the size of the reader if it has one)
+	contentLength int64
+}
+
+// Option configures a connection to a pipe
+type Option func(*options)
+
+// WithMode sets the mode of the connection
+func WithMode(mode Mode) Option {
+	return func(o *options) {
+		o.mode = mode
+	}
+}
+
+// WithUser sets the username (and optional password) sent with basic auth
+func WithUser(username, password string) Option {
+	return func(o *options) {
+		o.username = username
+		o.password = password
+	}
+}
+
+// WithRetries retries a connection that fails before any data is sent
+func WithRetries(retries int, delay time.Duration) Option {
+	return func(o *options) {
+		o.retries = retries
+		o.retryDelay = delay
+	}
+}
+
+// WithHTTPClient uses a custom http client
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *options) {
+		o.client = client
+	}
+}
+
+// WithContentLength sets the size of the data sent with Send
+// it isn't needed for readers that know their size (strings.Reader, bytes.Reader, bytes.Buffer and files)
+// without a size the upload is chunked
+func WithContentLength(length int64) Option {
+	return func(o *options) {
+		o.contentLength = length
+	}
+}
+
+func makeOptions(opts []Option) options {
+	o := options{client: http.DefaultClient, contentLength: -1}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// build the request for a pipe url with the mode and user applied
+func (o options) request(ctx context.Context, method, pipeURL string, body io.Reader) (*http.Request, error) {
+	u, err := url.Parse(pipeURL)
+	if err != nil {
+		return nil, err
+	}
+	if o.mode != ModeDefault {
+		query := u.Query()
+		query.Set("mode", string(o.mode))
+		u.RawQuery = query.Encode()
+	}
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if len(o.username) > 0 {
+		req.SetBasicAuth(o.username, o.password)
+	}
+	return req, nil
+}
+
+// send the request - retrying failed connections if allowed
+// retry is called before each retry and returns false if it isn't safe to try again
+func (o options) do(ctx context.Context, newRequest func() (*http.Request, error), retry func() bool) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+		resp, err := o.client.Do(req)
+		if err == nil || attempt >= o.retries || !retry() {
+			return resp, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(o.retryDelay):
+		}
+	}
+}
+
+// check the response status and turn failures into errors
+func checkStatus(resp *http.Response, failure error) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	resp.Body.Close()
+	err := &StatusError{
+		StatusCode: resp.StatusCode,
+		Message:    strings.TrimSpace(string(body)),
+	}
+	if failure != nil {
+		err.err = failure
+	}
+	return err
+}
+
+// readTracker records whether any data was read from the request body
+// a request can only be retried if nothing was sent
+type readTracker struct {
+	reader io.Reader
+	read   bool
+}
+
+func (t *readTracker) Read(p []byte) (int, error) {
+	t.read = true
+	return t.reader.Read(p)
+}
+
+// readerSize returns the number of bytes left in a reader that knows its size or -1 if it doesn't
+func readerSize(r io.Reader) int64 {
+	switch sized := r.(type) {
+	case interface{ Len() int }:
+		return int64(sized.Len())
+	case *os.File:
+		info, err := sized.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			return -1
+		}
+		offset, err := sized.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -1
+		}
+		return info.Size() - offset
+	}
+	return -1
+}
+
+// Send streams the data from the reader to the pipe and waits for the upload to finish
+// the upload has a Content-Length if the size of the reader is known (see WithContentLength)
+// in fail mode ErrNoReceivers is returned if nobody is listening
+func Send(ctx context.Context, pipeURL string, r io.Reader, opts ...Option) error {
+	o := makeOptions(opts)
+	length := o.contentLength
+	if length < 0 {
+		length = readerSize(r)
+	}
+	body := &readTracker{reader: r}
+	resp, err := o.do(ctx, func() (*http.Request, error) {
+		req, err := o.request(ctx, http.MethodPut, pipeURL, io.NopCloser(body))
+		if err != nil {
+			return nil, err
+		}
+		// the body is wrapped to track reads so net/http can't find its size
+		if length == 0 {
+			req.Body = http.NoBody
+		}
+		if length >= 0 {
+			req.ContentLength = length
+		}
+		return req, nil
+	}, func() bool {
+		return !body.read
+	})
+	if err != nil {
+		return err
+	}
+	var failure error
+	if o.mode == ModeFail && resp.StatusCode == http.StatusExpectationFailed {
+		failure = ErrNoReceivers
+	}
+	if err := checkStatus(resp, failure); err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(io.Discard, resp.Body)
+	return err
+}
+
+// Receive connects to the pipe and returns the data sent by any senders
+// the caller must close the returned reader
+// in fail mode ErrNoSenders is returned if nobody is sending
+func Receive(ctx context.Context, pipeURL string, opts ...Option) (io.ReadCloser, error) {
+	o := makeOptions(opts)
+	resp, err := o.do(ctx, func() (*http.Request, error) {
+		return o.request(ctx, http.MethodGet, pipeURL, nil)
+	}, func() bool {
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	var failure error
+	if o.mode == ModeFail && resp.StatusCode == http.StatusInternalServerError {
+		failure = ErrNoSenders
+	}
+	if err := checkStatus(resp, failure); err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// New asks the server for a new random pipe url
+func New(ctx context.Context, serverURL string, opts ...Option) (string, error) {
+	o := makeOptions(opts)
+	newURL := strings.TrimSuffix(serverURL, "/") + "/new"
+	resp, err := o.do(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodGet, newURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "text/plain")
+		return req.WithContext(ctx), nil
+	}, func() bool {
+		return true
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := checkStatus(resp, nil); err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// Conn is a full-duplex connection to a pipe
+// writes are sent to the pipe and reads return data from other senders
+type Conn struct {
+	writer *io.PipeWriter
+	body   io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Read data sent by other senders on the pipe
+func (c *Conn) Read(p []byte) (int, error) {
+	return c.body.Read(p)
+}
+
+// Write data to the pipe
+func (c *Conn) Write(p []byte) (int, error) {
+	return c.writer.Write(p)
+}
+
+// CloseWrite finishes the upload - data from other senders can still be read
+func (c *Conn) CloseWrite() error {
+	return c.writer.Close()
+}
+
+// Close the connection in both directions
+func (c *Conn) Close() error {
+	c.writer.Close()
+	c.cancel()
+	return c.body.Close()
+}
+
+// Dial opens a full-duplex connection to the pipe
+// it returns once the server is ready to exchange data
+// use WithMode(ModeInteractive) to chat with other connections
+func Dial(ctx context.Context, pipeURL string, opts ...Option) (*Conn, error) {
+	o := makeOptions(opts)
+	ctx, cancel := context.WithCancel(ctx)
+	reader, writer := io.Pipe()
+	// nothing has been written before the connection is returned so it is always safe to retry
+	resp, err := o.do(ctx, func() (*http.Request, error) {
+		return o.request(ctx, http.MethodPut, pipeURL, io.NopCloser(reader))
+	}, func() bool {
+		return true
+	})
+	if err == nil {
+		err = checkStatus(resp, nil)
+	}
+	if err != nil {
+		cancel()
+		writer.CloseWithError(err)
+		return nil, err
+	}
+	return &Conn{
+		writer: writer,
+		body:   resp.Body,
+		cancel: cancel,
+	}, nil
+}
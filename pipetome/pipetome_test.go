@@ -0,0 +1,129 @@
+package pipetome
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRequest(t *testing.T) {
+	o := makeOptions([]Option{WithMode(ModeBlock), WithUser("user", "pass")})
+	req, err := o.request(context.Background(), http.MethodGet, "https://pipeto.me/key?format=jsonl", nil)
+	if err != nil {
+		t.Fatalf("Error creating request: %s", err.Error())
+	}
+	if req.URL.Query().Get("mode") != "block" || req.URL.Query().Get("format") != "jsonl" {
+		t.Errorf("Invalid query: %s", req.URL.RawQuery)
+	}
+	username, password, ok := req.BasicAuth()
+	if !ok || username != "user" || password != "pass" {
+		t.Errorf("Invalid basic auth: %s %s", username, password)
+	}
+
+	o = makeOptions(nil)
+	req, _ = o.request(context.Background(), http.MethodGet, "https://pipeto.me/key", nil)
+	if len(req.URL.RawQuery) > 0 {
+		t.Errorf("Unexpected query: %s", req.URL.RawQuery)
+	}
+	if _, _, ok := req.BasicAuth(); ok {
+		t.Error("Unexpected basic auth")
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Pipe already in use", http.StatusConflict)
+	}))
+	defer ts.Close()
+
+	err := Send(context.Background(), ts.URL+"/key", strings.NewReader("data"), WithMode(ModeFail))
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("Expected status error: %v", err)
+	}
+	if statusErr.StatusCode != http.StatusConflict || statusErr.Message != "Pipe already in use" {
+		t.Errorf("Invalid status error: %d %s", statusErr.StatusCode, statusErr.Message)
+	}
+	// only the fail mode status codes are typed
+	if errors.Is(err, ErrNoReceivers) || errors.Is(err, ErrNoSenders) {
+		t.Errorf("Unexpected fail mode error: %v", err)
+	}
+}
+
+func TestSendContentLength(t *testing.T) {
+	lengths := make(chan int64, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		lengths <- r.ContentLength
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		reader   io.Reader
+		opts     []Option
+		expected int64
+	}{
+		{strings.NewReader("data"), nil, 4},
+		{bytes.NewBufferString(""), nil, 0},
+		// readers without a size are chunked unless the caller knows it
+		{io.MultiReader(strings.NewReader("data")), nil, -1},
+		{io.MultiReader(strings.NewReader("data")), []Option{WithContentLength(4)}, 4},
+	}
+	for _, test := range tests {
+		if err := Send(context.Background(), ts.URL+"/key", test.reader, test.opts...); err != nil {
+			t.Fatalf("Error sending: %s", err.Error())
+		}
+		if length := <-lengths; length != test.expected {
+			t.Errorf("Invalid content length: %d %d", test.expected, length)
+		}
+	}
+
+	// files are sent from their current offset
+	f, _ := os.CreateTemp(t.TempDir(), "send")
+	f.WriteString("file data")
+	f.Seek(5, io.SeekStart)
+	defer f.Close()
+	if err := Send(context.Background(), ts.URL+"/key", f); err != nil {
+		t.Fatalf("Error sending file: %s", err.Error())
+	}
+	if length := <-lengths; length != 4 {
+		t.Errorf("Invalid file content length: %d", length)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	var attempts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// drop the connection on the first attempt
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		w.Write([]byte("data"))
+	}))
+	defer ts.Close()
+
+	if _, err := Receive(context.Background(), ts.URL+"/key"); err == nil {
+		t.Error("Expected error without retries")
+	}
+
+	atomic.StoreInt32(&attempts, 0)
+	body, err := Receive(context.Background(), ts.URL+"/key", WithRetries(1, time.Millisecond))
+	if err != nil {
+		t.Fatalf("Error receiving with retries: %s", err.Error())
+	}
+	defer body.Close()
+	data, _ := io.ReadAll(body)
+	if string(data) != "data" || atomic.LoadInt32(&attempts) != 2 {
+		t.Errorf("Invalid retry: %s %d", string(data), attempts)
+	}
+}
@@ -0,0 +1,502 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// QR codes are generated in byte mode with medium error correction
+// versions 1-10 hold up to 213 bytes which is plenty for a pipe url
+
+// ErrQRTooLong is returned when the data doesn't fit in the supported versions
+var ErrQRTooLong = errors.New("data too long for a qr code")
+
+const qrMaxVersion = 10
+
+// error correction codewords per block and number of blocks for each version at level M
+var (
+	qrECCPerBlock = [qrMaxVersion + 1]int{0, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26}
+	qrBlocks      = [qrMaxVersion + 1]int{0, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5}
+)
+
+// QRCode is a square grid of dark (true) and light modules
+type QRCode struct {
+	size     int
+	modules  [][]bool
+	function [][]bool // modules that are part of the fixed patterns
+}
+
+// Dark returns whether the module at x, y is dark
+func (q *QRCode) Dark(x, y int) bool {
+	return q.modules[y][x]
+}
+
+// Size returns the width and height of the code in modules
+func (q *QRCode) Size() int {
+	return q.size
+}
+
+// EncodeQR builds the smallest qr code that holds the data
+func EncodeQR(data []byte) (*QRCode, error) {
+	version := 1
+	for ; version <= qrMaxVersion; version++ {
+		if len(data) <= qrDataCapacity(version)-qrHeaderBytes(version) {
+			break
+		}
+	}
+	if version > qrMaxVersion {
+		return nil, ErrQRTooLong
+	}
+
+	size := version*4 + 17
+	q := &QRCode{size: size, modules: qrGrid(size), function: qrGrid(size)}
+	q.drawFunctionPatterns(version)
+	q.drawCodewords(qrAddECC(qrDataCodewords(data, version), version))
+
+	// use the mask with the lowest penalty
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask)
+		q.drawFormatBits(mask)
+		if penalty := q.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		// masking twice undoes it
+		q.applyMask(mask)
+	}
+	q.applyMask(best)
+	q.drawFormatBits(best)
+	return q, nil
+}
+
+func qrGrid(size int) [][]bool {
+	grid := make([][]bool, size)
+	for i := range grid {
+		grid[i] = make([]bool, size)
+	}
+	return grid
+}
+
+// the number of bytes that hold data (not error correction) in a version
+func qrDataCapacity(version int) int {
+	return qrRawModules(version)/8 - qrECCPerBlock[version]*qrBlocks[version]
+}
+
+// the mode indicator and length take 12 bits (20 bits from version 10)
+func qrHeaderBytes(version int) int {
+	if version < 10 {
+		return 2
+	}
+	return 3
+}
+
+// the number of modules that are available for data and error correction
+func qrRawModules(version int) int {
+	result := (16*version+128)*version + 64
+	if version >= 2 {
+		align := version/7 + 2
+		result -= (25*align-10)*align - 55
+		if version >= 7 {
+			result -= 36
+		}
+	}
+	return result
+}
+
+// qrBits appends bits to a byte slice most significant bit first
+type qrBits struct {
+	data []byte
+	len  int
+}
+
+func (b *qrBits) append(value, count int) {
+	for i := count - 1; i >= 0; i-- {
+		if b.len%8 == 0 {
+			b.data = append(b.data, 0)
+		}
+		if (value>>uint(i))&1 != 0 {
+			b.data[b.len/8] |= 0x80 >> uint(b.len%8)
+		}
+		b.len++
+	}
+}
+
+// encode the data in byte mode and pad it to the capacity of the version
+func qrDataCodewords(data []byte, version int) []byte {
+	capacity := qrDataCapacity(version)
+	var bits qrBits
+	bits.append(0x4, 4)
+	if version < 10 {
+		bits.append(len(data), 8)
+	} else {
+		bits.append(len(data), 16)
+	}
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+	// terminator and padding to a whole byte
+	terminator := capacity*8 - bits.len
+	if terminator > 4 {
+		terminator = 4
+	}
+	bits.append(0, terminator)
+	bits.append(0, (8-bits.len%8)%8)
+	for pad := 0xEC; len(bits.data) < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+	return bits.data
+}
+
+// split the data into blocks, add the error correction to each and interleave them
+func qrAddECC(data []byte, version int) []byte {
+	blocks := qrBlocks[version]
+	eccLen := qrECCPerBlock[version]
+	raw := qrRawModules(version) / 8
+	shortBlocks := blocks - raw%blocks
+	shortLen := raw / blocks
+	divisor := rsDivisor(eccLen)
+
+	var all [][]byte
+	k := 0
+	for i := 0; i < blocks; i++ {
+		n := shortLen - eccLen
+		if i >= shortBlocks {
+			n++
+		}
+		block := append([]byte{}, data[k:k+n]...)
+		k += n
+		ecc := rsRemainder(block, divisor)
+		if i < shortBlocks {
+			// a placeholder so that all of the blocks line up
+			block = append(block, 0)
+		}
+		all = append(all, append(block, ecc...))
+	}
+
+	var result []byte
+	for i := range all[0] {
+		for j, block := range all {
+			// skip the placeholders in short blocks
+			if i != shortLen-eccLen || j >= shortBlocks {
+				result = append(result, block[i])
+			}
+		}
+	}
+	return result
+}
+
+// multiply in GF(256) with the qr code polynomial
+func gfMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>uint(i))&1) * int(x)
+	}
+	return byte(z)
+}
+
+// the reed-solomon generator polynomial (without the leading term)
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+// the error correction codewords for the data
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i := range result {
+			result[i] ^= gfMultiply(divisor[i], factor)
+		}
+	}
+	return result
+}
+
+func (q *QRCode) setFunction(x, y int, dark bool) {
+	q.modules[y][x] = dark
+	q.function[y][x] = true
+}
+
+// draw the finder, timing, alignment and version patterns
+func (q *QRCode) drawFunctionPatterns(version int) {
+	for i := 0; i < q.size; i++ {
+		q.setFunction(6, i, i%2 == 0)
+		q.setFunction(i, 6, i%2 == 0)
+	}
+
+	q.drawFinder(3, 3)
+	q.drawFinder(q.size-4, 3)
+	q.drawFinder(3, q.size-4)
+
+	positions := qrAlignmentPositions(version)
+	last := len(positions) - 1
+	for i, x := range positions {
+		for j, y := range positions {
+			// the corners with finder patterns
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			q.drawAlignment(x, y)
+		}
+	}
+
+	// reserve the format bits so that data isn't drawn over them
+	q.drawFormatBits(0)
+	q.drawVersion(version)
+}
+
+func absInt(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func (q *QRCode) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			dist := max(absInt(dx), absInt(dy))
+			xx, yy := x+dx, y+dy
+			if xx >= 0 && xx < q.size && yy >= 0 && yy < q.size {
+				q.setFunction(xx, yy, dist != 2 && dist != 4)
+			}
+		}
+	}
+}
+
+func (q *QRCode) drawAlignment(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			q.setFunction(x+dx, y+dy, max(absInt(dx), absInt(dy)) != 1)
+		}
+	}
+}
+
+// the centres of the alignment patterns on each axis
+func qrAlignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	count := version/7 + 2
+	step := (version*4 + count*2 + 1) / (count*2 - 2) * 2
+	positions := make([]int, count)
+	positions[0] = 6
+	for i, pos := count-1, version*4+10; i > 0; i, pos = i-1, pos-step {
+		positions[i] = pos
+	}
+	return positions
+}
+
+// draw both copies of the error correction level (M) and mask
+func (q *QRCode) drawFormatBits(mask int) {
+	data := mask // level M is 00
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool {
+		return (bits>>uint(i))&1 != 0
+	}
+
+	for i := 0; i <= 5; i++ {
+		q.setFunction(8, i, bit(i))
+	}
+	q.setFunction(8, 7, bit(6))
+	q.setFunction(8, 8, bit(7))
+	q.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.setFunction(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		q.setFunction(q.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.setFunction(8, q.size-15+i, bit(i))
+	}
+	// always dark
+	q.setFunction(8, q.size-8, true)
+}
+
+// versions 7 and up store the version number next to two of the finders
+func (q *QRCode) drawVersion(version int) {
+	if version < 7 {
+		return
+	}
+	rem := version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+	bits := version<<12 | rem
+	for i := 0; i < 18; i++ {
+		dark := (bits>>uint(i))&1 != 0
+		a, b := q.size-11+i%3, i/3
+		q.setFunction(a, b, dark)
+		q.setFunction(b, a, dark)
+	}
+}
+
+// draw the data in the zigzag pattern from the bottom right corner
+func (q *QRCode) drawCodewords(data []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		// skip the vertical timing pattern
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < q.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = q.size - 1 - vert
+				}
+				if !q.function[y][x] && i < len(data)*8 {
+					q.modules[y][x] = (data[i/8]>>uint(7-i%8))&1 != 0
+					i++
+				}
+			}
+		}
+	}
+}
+
+// flip the data modules that match the mask pattern
+func (q *QRCode) applyMask(mask int) {
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !q.function[y][x] {
+				q.modules[y][x] = !q.modules[y][x]
+			}
+		}
+	}
+}
+
+// score how hard the code is to scan (lower is better)
+func (q *QRCode) penalty() int {
+	result := 0
+	finderLike := []bool{true, false, true, true, true, false, true, false, false, false, false}
+	matches := func(get func(i int) bool, start int, pattern []bool, reverse bool) bool {
+		for i, dark := range pattern {
+			j := i
+			if reverse {
+				j = len(pattern) - 1 - i
+			}
+			if get(start+j) != dark {
+				return false
+			}
+		}
+		return true
+	}
+
+	for line := 0; line < q.size; line++ {
+		for _, get := range []func(i int) bool{
+			func(i int) bool { return q.modules[line][i] },
+			func(i int) bool { return q.modules[i][line] },
+		} {
+			// runs of the same colour
+			run := 1
+			for i := 1; i <= q.size; i++ {
+				if i < q.size && get(i) == get(i-1) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					result += 3 + run - 5
+				}
+				run = 1
+			}
+			// patterns that look like finders
+			for i := 0; i+len(finderLike) <= q.size; i++ {
+				if matches(get, i, finderLike, false) || matches(get, i, finderLike, true) {
+					result += 40
+				}
+			}
+		}
+	}
+
+	dark := 0
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if q.modules[y][x] {
+				dark++
+			}
+			// 2x2 blocks of the same colour
+			if x+1 < q.size && y+1 < q.size {
+				c := q.modules[y][x]
+				if c == q.modules[y][x+1] && c == q.modules[y+1][x] && c == q.modules[y+1][x+1] {
+					result += 3
+				}
+			}
+		}
+	}
+
+	// balance of dark and light modules
+	total := q.size * q.size
+	k := (absInt(dark*20-total*10)+total-1)/total - 1
+	result += k * 10
+	return result
+}
+
+// Terminal renders the code with unicode half blocks, two rows of modules per line
+// light modules are drawn so that it scans on a dark terminal
+func (q *QRCode) Terminal() string {
+	const margin = 2
+	light := func(x, y int) bool {
+		if x < 0 || y < 0 || x >= q.size || y >= q.size {
+			return true
+		}
+		return !q.modules[y][x]
+	}
+	var b strings.Builder
+	for y := -margin; y < q.size+margin; y += 2 {
+		for x := -margin; x < q.size+margin; x++ {
+			top, bottom := light(x, y), light(x, y+1) && y+1 < q.size+margin
+			switch {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
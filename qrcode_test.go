@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestRSRemainder(t *testing.T) {
+	// HELLO WORLD as version 1-M from the qr code specification tutorial
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	expected := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if actual := rsRemainder(data, rsDivisor(10)); !bytes.Equal(actual, expected) {
+		t.Errorf("Invalid error correction: %v %v", expected, actual)
+	}
+}
+
+func TestEncodeQRVersion(t *testing.T) {
+	tests := map[int]int{
+		1:   21,
+		14:  21,
+		15:  25,
+		60:  33,
+		63:  37,
+		213: 57,
+	}
+	for length, size := range tests {
+		q, err := EncodeQR(bytes.Repeat([]byte("a"), length))
+		if err != nil {
+			t.Errorf("Error encoding %d bytes: %s", length, err.Error())
+			continue
+		}
+		if q.Size() != size {
+			t.Errorf("Invalid size for %d bytes: %d %d", length, size, q.Size())
+		}
+	}
+	if _, err := EncodeQR(bytes.Repeat([]byte("a"), 214)); err != ErrQRTooLong {
+		t.Errorf("Expected error for long data: %v", err)
+	}
+}
+
+// read the data back out of the code the way a scanner would
+func decodeQR(t *testing.T, q *QRCode, version int) []byte {
+	// format bits from around the top left finder
+	format := 0
+	for i := 0; i <= 5; i++ {
+		format |= boolBit(q.Dark(8, i)) << uint(i)
+	}
+	format |= boolBit(q.Dark(8, 7)) << 6
+	format |= boolBit(q.Dark(8, 8)) << 7
+	format |= boolBit(q.Dark(7, 8)) << 8
+	for i := 9; i < 15; i++ {
+		format |= boolBit(q.Dark(14-i, 8)) << uint(i)
+	}
+	format ^= 0x5412
+	if format>>13 != 0 {
+		t.Errorf("Invalid error correction level: %d", format>>13)
+	}
+	mask := (format >> 10) & 7
+	rem := format >> 10
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	if format != (format>>10)<<10|rem {
+		t.Errorf("Invalid format error correction: %x", format)
+	}
+
+	// the second copy must match
+	second := 0
+	for i := 0; i < 8; i++ {
+		second |= boolBit(q.Dark(q.size-1-i, 8)) << uint(i)
+	}
+	for i := 8; i < 15; i++ {
+		second |= boolBit(q.Dark(8, q.size-15+i)) << uint(i)
+	}
+	if second^0x5412 != format {
+		t.Errorf("Format copies don't match: %x %x", format, second^0x5412)
+	}
+
+	q.applyMask(mask)
+	defer q.applyMask(mask)
+	var bits qrBits
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < q.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = q.size - 1 - vert
+				}
+				if !q.function[y][x] {
+					bits.append(boolBit(q.modules[y][x]), 1)
+				}
+			}
+		}
+	}
+
+	// de-interleave equal sized blocks
+	blocks := qrBlocks[version]
+	capacity := qrDataCapacity(version)
+	data := make([]byte, capacity)
+	perBlock := capacity / blocks
+	for i := 0; i < capacity; i++ {
+		data[(i%blocks)*perBlock+i/blocks] = bits.data[i]
+	}
+	if data[0]>>4 != 0x4 {
+		t.Errorf("Invalid mode: %x", data[0]>>4)
+	}
+	length := int(data[0]&0xF)<<4 | int(data[1]>>4)
+	decoded := make([]byte, length)
+	for i := range decoded {
+		decoded[i] = data[i+1]<<4 | data[i+2]>>4
+	}
+	return decoded
+}
+
+func boolBit(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func TestEncodeQRRoundTrip(t *testing.T) {
+	tests := map[string]int{
+		"https://pipeto.me/abcdefgh": 2,
+		strings.Repeat("x", 80):      5,
+	}
+	for input, version := range tests {
+		q, err := EncodeQR([]byte(input))
+		if err != nil {
+			t.Fatalf("Error encoding: %s", err.Error())
+		}
+		if q.Size() != version*4+17 {
+			t.Errorf("Invalid version for %q: %d %d", input, version*4+17, q.Size())
+		}
+		if decoded := decodeQR(t, q, version); string(decoded) != input {
+			t.Errorf("Invalid round trip: %q %q", input, string(decoded))
+		}
+	}
+}
+
+func TestQRTerminal(t *testing.T) {
+	q, _ := EncodeQR([]byte("https://pipeto.me/abcdefgh"))
+	lines := strings.Split(strings.TrimSuffix(q.Terminal(), "\n"), "\n")
+	// 25 modules plus a margin of 2 on each side, two rows per line
+	if len(lines) != 15 {
+		t.Errorf("Invalid number of lines: %d", len(lines))
+	}
+	for _, line := range lines {
+		if utf8.RuneCountInString(line) != 29 {
+			t.Errorf("Invalid line width: %d", utf8.RuneCountInString(line))
+		}
+	}
+	// the margin is light
+	if !strings.HasPrefix(lines[0], "█████") {
+		t.Errorf("Invalid margin: %s", lines[0])
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Receipt summarizes the delivery of the data from a single sender
+type Receipt struct {
+	BytesSent    int64         `json:"bytes_sent"`
+	Delivered    int           `json:"delivered"`    // receivers connected when the stream was closed
+	Disconnected int           `json:"disconnected"` // receivers that left part way through the transfer
+	Duration     time.Duration `json:"duration_ns"`
+}
+
+func (rc Receipt) String() string {
+	return fmt.Sprintf("receipt: %d bytes sent to %d receivers (%d disconnected) in %s\n",
+		rc.BytesSent,
+		rc.Delivered,
+		rc.Disconnected,
+		rc.Duration.Round(time.Millisecond))
+}
+
+// Write the receipt as json or plain text depending on the Accept header of the request
+func (rc Receipt) Write(w io.Writer, r *http.Request) error {
+	if negotiate(r, textType, jsonType) == jsonType {
+		return json.NewEncoder(w).Encode(rc)
+	}
+	_, err := io.WriteString(w, rc.String())
+	return err
+}
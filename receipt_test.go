@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func acceptRequest(accept string) *http.Request {
+	r := httptest.NewRequest("PUT", "/key?receipt=1", nil)
+	r.Header.Set("Accept", accept)
+	return r
+}
+
+func TestReceiptWrite(t *testing.T) {
+	receipt := Receipt{BytesSent: 10, Delivered: 2, Disconnected: 1, Duration: 1500 * time.Millisecond}
+
+	var text bytes.Buffer
+	receipt.Write(&text, acceptRequest("*/*"))
+	expected := "receipt: 10 bytes sent to 2 receivers (1 disconnected) in 1.5s\n"
+	if text.String() != expected {
+		t.Errorf("Invalid text receipt: %q %q", expected, text.String())
+	}
+
+	var json bytes.Buffer
+	receipt.Write(&json, acceptRequest("application/json"))
+	expected = `{"bytes_sent":10,"delivered":2,"disconnected":1,"duration_ns":1500000000}` + "\n"
+	if json.String() != expected {
+		t.Errorf("Invalid json receipt: %q %q", expected, json.String())
+	}
+
+	// json that the client refuses isn't sent
+	var refused bytes.Buffer
+	receipt.Write(&refused, acceptRequest("application/json;q=0, text/plain"))
+	if !strings.HasPrefix(refused.String(), "receipt: ") {
+		t.Errorf("Invalid receipt for refused json: %q", refused.String())
+	}
+}
@@ -1,8 +1,18 @@
 package main
 
 import (
+	"errors"
 	"io"
 	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	// errStopped is returned for a write to a receiver whose handler has finished with the response
+	errStopped = errors.New("receiver has stopped")
+	// errFailed is returned for a write to a receiver after an earlier write failed
+	errFailed = errors.New("receiver has failed")
 )
 
 // RecieveWriter is an interface that allows writing to a receiver
@@ -10,7 +20,7 @@ import (
 type RecieveWriter interface {
 	io.WriteCloser
 	ID() int
-	Interactive() bool
+	Formatter() Formatter
 	Username() string
 }
 
@@ -18,12 +28,24 @@ type RecieveWriter interface {
 // a writer that is automatically flushed back to the receiver client
 // and a notification channel when it is closed
 type Receiver struct {
-	id          int
-	interactive bool
-	username    string
-	writer      io.Writer
-	flusher     http.Flusher
-	done        chan bool
+	id        int
+	formatter Formatter
+	username  string
+	writer    io.Writer
+	flusher   http.Flusher
+	done      chan bool
+	// senders write from their own goroutines and compressed writers can't be shared
+	mu *sync.Mutex
+	// set once the handler is finished with the response (guarded by mu)
+	stopped *bool
+	// sets write deadlines so that a write to a half-open connection fails instead of blocking
+	controller   *http.ResponseController
+	writeTimeout time.Duration
+	// when the receiver was last written to (see KeepAlive)
+	activity *activity
+	// closed once a write fails
+	failed   chan bool
+	failOnce *sync.Once
 }
 
 // ID returns the identifier for this reader
@@ -31,9 +53,9 @@ func (r Receiver) ID() int {
 	return r.id
 }
 
-// Interactive returns whether or not to show connect/disconnect messages to the receiver
-func (r Receiver) Interactive() bool {
-	return r.interactive
+// Formatter returns how messages are rendered for the receiver
+func (r Receiver) Formatter() Formatter {
+	return r.formatter
 }
 
 // Username returns the username supplied by the receiver (or client <id> if none was supplied)
@@ -43,34 +65,89 @@ func (r Receiver) Username() string {
 
 // Write a single received buffer to the receiver and flush it back to the client
 func (r Receiver) Write(p []byte) (n int, err error) {
+	return r.write(p, false)
+}
+
+// Heartbeat writes data that keeps a quiet connection alive - it doesn't count as activity
+func (r Receiver) Heartbeat(p []byte) error {
+	_, err := r.write(p, true)
+	return err
+}
+
+func (r Receiver) write(p []byte, heartbeat bool) (n int, err error) {
 	if len(p) < 1 {
 		return
 	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if *r.stopped {
+		return 0, errStopped
+	}
+	select {
+	case <-r.failed:
+		return 0, errFailed
+	default:
+	}
+	if r.controller != nil && r.writeTimeout > 0 {
+		r.controller.SetWriteDeadline(time.Now().Add(r.writeTimeout))
+		defer r.controller.SetWriteDeadline(time.Time{})
+	}
 	n, err = r.writer.Write(p)
 	r.flusher.Flush()
+	if err != nil {
+		r.failOnce.Do(func() { close(r.failed) })
+		return
+	}
+	r.activity.wrote(heartbeat)
 	return
 }
 
+// Failed returns a notification channel that is closed once a write to the receiver fails
+func (r Receiver) Failed() <-chan bool {
+	return r.failed
+}
+
 // Close the receiver. flush it one last time and notify that it is closed
+// the notification is only sent once and doesn't wait for the handler
 func (r Receiver) Close() error {
-	r.flusher.Flush()
-	r.done <- true
+	r.mu.Lock()
+	if !*r.stopped {
+		r.flusher.Flush()
+	}
+	r.mu.Unlock()
+	select {
+	case r.done <- true:
+	default:
+	}
 	return nil
 }
 
+// Stop writing to the receiver - a sender may still hold it after it is removed from its pipe
+// so the handler stops it before it closes or compresses the rest of the response
+func (r Receiver) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	*r.stopped = true
+}
+
 // CloseNotify returns a notification channel that will tell when the reciever has been closed
 func (r Receiver) CloseNotify() <-chan bool {
 	return r.done
 }
 
 // MakeReceiver creates a new receiver struct
-func MakeReceiver(w io.Writer, f http.Flusher, id int, interactive bool, username string) Receiver {
+func MakeReceiver(w io.Writer, f http.Flusher, id int, formatter Formatter, username string) Receiver {
 	return Receiver{
-		writer:      w,
-		flusher:     f,
-		id:          id,
-		interactive: interactive,
-		username:    username,
-		done:        make(chan bool),
+		writer:    w,
+		flusher:   f,
+		id:        id,
+		formatter: formatter,
+		username:  username,
+		done:      make(chan bool, 1),
+		mu:        &sync.Mutex{},
+		stopped:   new(bool),
+		activity:  makeActivity(),
+		failed:    make(chan bool),
+		failOnce:  &sync.Once{},
 	}
 }
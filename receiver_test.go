@@ -16,7 +16,7 @@ func (t *TestFlusher) Flush() {
 func TestReceiverWrite(t *testing.T) {
 	var w bytes.Buffer
 	f := TestFlusher{flushCount: 0}
-	receiver := MakeReceiver(&w, &f, 0, false, "")
+	receiver := MakeReceiver(&w, &f, 0, RawFormatter{}, "")
 
 	input := "test input"
 	count, err := receiver.Write([]byte(input))
@@ -41,7 +41,7 @@ func TestReceiverWrite(t *testing.T) {
 func TestReceiverClose(t *testing.T) {
 	var w bytes.Buffer
 	f := TestFlusher{flushCount: 0}
-	receiver := MakeReceiver(&w, &f, 0, false, "")
+	receiver := MakeReceiver(&w, &f, 0, RawFormatter{}, "")
 
 	go func() {
 		receiver.Write([]byte("test input"))
@@ -58,3 +58,20 @@ func TestReceiverClose(t *testing.T) {
 		t.Errorf("Final flush not called appropriately: %d", f.flushCount)
 	}
 }
+
+func TestReceiverStop(t *testing.T) {
+	var w bytes.Buffer
+	f := TestFlusher{flushCount: 0}
+	receiver := MakeReceiver(&w, &f, 0, RawFormatter{}, "")
+	receiver.Stop()
+
+	if _, err := receiver.Write([]byte("test input")); err != errStopped || w.Len() > 0 {
+		t.Errorf("Stopped receiver should not be written to: %v %q", err, w.String())
+	}
+	// closing a receiver whose handler has finished doesn't wait for it
+	receiver.Close()
+	receiver.Close()
+	if f.flushCount != 0 {
+		t.Errorf("Stopped receiver should not be flushed: %d", f.flushCount)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the longest pause between two messages when a recording is played back
+// (recordings can span several sessions hours apart)
+const maxReplayPause = 5 * time.Second
+
+// ErrRecordingFull is returned once a recording reaches its maximum size
+var ErrRecordingFull = errors.New("recording is full")
+
+// RecordedMessage is a single line of a recording
+type RecordedMessage struct {
+	Time   time.Time `json:"time"`
+	ID     int       `json:"id"`
+	User   string    `json:"user"`
+	System bool      `json:"system"`
+	Data   []byte    `json:"data"`
+	// the new size of a shared terminal for resize events
+	Size string `json:"size,omitempty"`
+}
+
+// Message converts the recorded message back so that it can be formatted for a receiver
+func (r RecordedMessage) Message(key string) Message {
+	m := Message{
+		fromID:   r.ID,
+		fromUser: r.User,
+		buffer:   r.Data,
+		system:   r.System,
+		key:      key,
+	}
+	if size, err := parseTerminalSize(r.Size); err == nil {
+		m.size = &size
+	}
+	return m
+}
+
+// Recorder appends everything written to a pipe to a file
+type Recorder struct {
+	mu       sync.Mutex
+	file     *os.File
+	written  int64
+	maxBytes int64 // 0 for unlimited
+	err      error
+}
+
+// Record a message - recording stops once the file reaches the maximum size
+func (rec *Recorder) Record(m Message) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.err != nil {
+		return
+	}
+	recorded := RecordedMessage{
+		Time:   time.Now(),
+		ID:     m.fromID,
+		User:   m.fromUser,
+		System: m.system,
+		Data:   m.buffer,
+	}
+	if m.size != nil {
+		recorded.Size = m.size.String()
+	}
+	line, err := json.Marshal(recorded)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+	if rec.maxBytes > 0 && rec.written+int64(len(line)) > rec.maxBytes {
+		rec.err = ErrRecordingFull
+		return
+	}
+	n, err := rec.file.Write(line)
+	rec.written += int64(n)
+	rec.err = err
+}
+
+// Close the recording file
+func (rec *Recorder) Close() error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.file.Close()
+}
+
+// OpenRecorder appends to the recording of a key in the directory
+func OpenRecorder(dir, key string, maxBytes int64) (*Recorder, error) {
+	path := recordingPath(dir, key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &Recorder{
+		file:     file,
+		written:  info.Size(),
+		maxBytes: maxBytes,
+	}, nil
+}
+
+// recordingPath returns the file for a key - keys with several parts are kept in subdirectories
+func recordingPath(dir, key string) string {
+	return filepath.Join(dir, filepath.FromSlash(key)+".jsonl")
+}
+
+// PlayRecording writes a recording with its original timing sped up by speed
+// long pauses are cut to maxReplayPause first and write is called for each message
+func PlayRecording(ctx context.Context, r io.Reader, speed float64, write func(RecordedMessage)) error {
+	var last time.Time
+	return readRecording(r, func(m RecordedMessage) error {
+		if !last.IsZero() {
+			pause := m.Time.Sub(last)
+			if pause > maxReplayPause {
+				pause = maxReplayPause
+			}
+			pause = time.Duration(float64(pause) / speed)
+			if pause > 0 {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(pause):
+				}
+			}
+		}
+		last = m.Time
+		write(m)
+		return nil
+	})
+}
+
+// readRecording calls read for each message of a recording until it returns an error
+func readRecording(r io.Reader, read func(RecordedMessage) error) error {
+	scanner := bufio.NewScanner(r)
+	// lines hold a whole upload buffer (base64 encoded)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var m RecordedMessage
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			return err
+		}
+		if err := read(m); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// ExpireRecordings deletes the recordings that haven't changed within the retention period
+func ExpireRecordings(dir string, retention time.Duration, now time.Time) {
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(path, ".jsonl") {
+			return nil
+		}
+		if now.Sub(info.ModTime()) > retention {
+			if err := os.Remove(path); err != nil {
+				log.Println("Error removing recording:", err)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecorder(t *testing.T) {
+	dir := t.TempDir()
+	rec, err := OpenRecorder(dir, "team/builds", 200)
+	if err != nil {
+		t.Fatalf("Error opening recorder: %s", err.Error())
+	}
+	rec.Record(Message{fromID: 1, fromUser: "alice", buffer: []byte("hello\n")})
+	rec.Record(Message{fromID: 1, buffer: []byte("a message that doesn't fit in the rest of the recording\n")})
+	rec.Record(Message{fromID: 1, buffer: []byte("too late\n")})
+	rec.Close()
+
+	file, err := os.Open(filepath.Join(dir, "team", "builds.jsonl"))
+	if err != nil {
+		t.Fatalf("Error opening recording: %s", err.Error())
+	}
+	defer file.Close()
+	var played []RecordedMessage
+	PlayRecording(context.Background(), file, 1, func(m RecordedMessage) {
+		played = append(played, m)
+	})
+	if len(played) != 1 || played[0].User != "alice" || string(played[0].Data) != "hello\n" {
+		t.Errorf("Invalid recording: %v", played)
+	}
+}
+
+func TestPlayRecording(t *testing.T) {
+	start := time.Now()
+	var recording bytes.Buffer
+	for i, offset := range []time.Duration{0, 100 * time.Millisecond, time.Hour} {
+		rec := RecordedMessage{Time: start.Add(offset), ID: i, Data: []byte("x")}
+		line, _ := json.Marshal(rec)
+		recording.Write(append(line, '\n'))
+	}
+
+	// sped up by 10 the pauses are 10ms and the cap (5s / 10)
+	played := 0
+	began := time.Now()
+	err := PlayRecording(context.Background(), bytes.NewReader(recording.Bytes()), 10, func(m RecordedMessage) {
+		played++
+	})
+	elapsed := time.Since(began)
+	if err != nil || played != 3 {
+		t.Errorf("Invalid playback: %d %v", played, err)
+	}
+	if elapsed < 10*time.Millisecond || elapsed > maxReplayPause {
+		t.Errorf("Invalid playback timing: %s", elapsed)
+	}
+
+	// playback stops when the viewer goes away
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := PlayRecording(ctx, bytes.NewReader(recording.Bytes()), 1, func(m RecordedMessage) {}); err != context.Canceled {
+		t.Errorf("Expected playback to be cancelled: %v", err)
+	}
+}
+
+func TestExpireRecordings(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, "old.jsonl")
+	recent := filepath.Join(dir, "recent.jsonl")
+	os.WriteFile(old, []byte("{}\n"), 0644)
+	os.WriteFile(recent, []byte("{}\n"), 0644)
+	now := time.Now()
+	os.Chtimes(old, now.Add(-48*time.Hour), now.Add(-48*time.Hour))
+
+	ExpireRecordings(dir, 24*time.Hour, now)
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("Expected the old recording to be removed")
+	}
+	if _, err := os.Stat(recent); err != nil {
+		t.Errorf("Expected the recent recording to be kept: %s", err.Error())
+	}
+}
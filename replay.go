@@ -0,0 +1,55 @@
+package main
+
+import "sync"
+
+// ReplayBuffer keeps the most recent messages sent through a pipe
+// new receivers get them before anything else so they can catch up
+type ReplayBuffer struct {
+	mu       sync.Mutex
+	size     int // the maximum number of bytes kept
+	bytes    int
+	messages []Message
+}
+
+// Add a message - the oldest messages are dropped once the buffer is full
+func (rb *ReplayBuffer) Add(m Message) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	// keep the end of a message that is bigger than the whole buffer
+	if len(m.buffer) > rb.size {
+		m.buffer = m.buffer[len(m.buffer)-rb.size:]
+	}
+	// copy the buffer since senders reuse it for the next read
+	m.buffer = append([]byte(nil), m.buffer...)
+	rb.messages = append(rb.messages, m)
+	rb.bytes += len(m.buffer)
+	rb.trim()
+}
+
+// Messages returns the buffered messages from oldest to newest
+func (rb *ReplayBuffer) Messages() []Message {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	return append([]Message(nil), rb.messages...)
+}
+
+// Resize changes the maximum number of bytes kept
+func (rb *ReplayBuffer) Resize(size int) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.size = size
+	rb.trim()
+}
+
+// drop the oldest messages until the buffer fits
+func (rb *ReplayBuffer) trim() {
+	for rb.bytes > rb.size && len(rb.messages) > 0 {
+		rb.bytes -= len(rb.messages[0].buffer)
+		rb.messages = rb.messages[1:]
+	}
+}
+
+// MakeReplayBuffer creates a buffer that keeps up to size bytes
+func MakeReplayBuffer(size int) *ReplayBuffer {
+	return &ReplayBuffer{size: size}
+}
@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+func TestReplayBuffer(t *testing.T) {
+	rb := MakeReplayBuffer(10)
+	rb.Add(Message{buffer: []byte("one\n")})
+	rb.Add(Message{buffer: []byte("two\n")})
+	rb.Add(Message{buffer: []byte("three\n")})
+
+	replayed := ""
+	for _, m := range rb.Messages() {
+		replayed += string(m.buffer)
+	}
+	if replayed != "two\nthree\n" {
+		t.Errorf("Invalid replay: %q", replayed)
+	}
+
+	// only the end of a message that doesn't fit is kept
+	rb.Add(Message{buffer: []byte("a very long message\n")})
+	if messages := rb.Messages(); len(messages) != 1 || string(messages[0].buffer) != "g message\n" {
+		t.Errorf("Invalid replay of long message: %v", messages)
+	}
+
+	rb.Resize(4)
+	if messages := rb.Messages(); len(messages) != 0 {
+		t.Errorf("Invalid replay after resize: %v", messages)
+	}
+}
+
+func TestPipeReplay(t *testing.T) {
+	pipe := MakePipe(&TestHandler{})
+	pipe.SetDefaults(PipeDefaults{Replay: 100, MaxReceivers: 1})
+	pipe.Write(Message{fromID: 1, buffer: []byte("before\n")})
+
+	r1 := &TestReceiver{}
+	pipe.AddReceiver(r1)
+	if r1.writer.String() != "before\n" {
+		t.Errorf("Invalid replay: %q", r1.writer.String())
+	}
+	if !pipe.Full() {
+		t.Errorf("Expected the pipe to be full")
+	}
+	pipe.AddSender()
+	pipe.AddReceiver(&TestReceiver{})
+	if !pipe.Full() {
+		t.Errorf("Senders shouldn't count as receivers")
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrSecretRequired is returned when a reservation is changed without a secret
+	ErrSecretRequired = errors.New("a secret is required")
+	// ErrKeyReserved is returned when a key is reserved with a different secret
+	ErrKeyReserved = errors.New("pipe is reserved")
+	// ErrNotReserved is returned when releasing a key that isn't reserved
+	ErrNotReserved = errors.New("pipe is not reserved")
+)
+
+// PipeDefaults are the settings the owner of a reserved key chooses for its pipe
+type PipeDefaults struct {
+	Mode         string `json:"mode,omitempty"`         // used when a request doesn't ask for a mode
+	Replay       int    `json:"replay,omitempty"`       // bytes of recent data sent to new receivers
+	MaxReceivers int    `json:"maxreceivers,omitempty"` // 0 for unlimited
+}
+
+func (d PipeDefaults) String() string {
+	var defaults []string
+	if len(d.Mode) > 0 {
+		defaults = append(defaults, fmt.Sprintf("mode: %s", d.Mode))
+	}
+	if d.Replay > 0 {
+		defaults = append(defaults, fmt.Sprintf("replay: %d bytes", d.Replay))
+	}
+	if d.MaxReceivers > 0 {
+		defaults = append(defaults, fmt.Sprintf("max receivers: %d", d.MaxReceivers))
+	}
+	if len(defaults) == 0 {
+		return "no defaults"
+	}
+	return strings.Join(defaults, ", ")
+}
+
+// Reservation gives the holder of a secret the exclusive right to create a pipe
+type Reservation struct {
+	Key      string       `json:"key"`
+	Secret   string       `json:"secret"` // a hash of the secret
+	Defaults PipeDefaults `json:"defaults"`
+	Created  time.Time    `json:"created"`
+}
+
+// Allows returns whether the secret matches the one used to reserve the key
+func (r Reservation) Allows(secret string) bool {
+	if len(secret) == 0 {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(r.Secret), []byte(hashSecret(r.Key, secret))) == 1
+}
+
+func (r Reservation) String() string {
+	return fmt.Sprintf("%s reserved (%s)", r.Key, r.Defaults)
+}
+
+// the secrets aren't stored - only a hash salted with the key
+func hashSecret(key, secret string) string {
+	sum := sha256.Sum256([]byte(key + ":" + secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// ReservationStore holds the reserved keys
+// they are saved to a json file (if set) whenever they change
+type ReservationStore struct {
+	path string
+
+	mu           sync.Mutex
+	reservations map[string]Reservation
+}
+
+// Find returns the reservation for a key
+func (rs *ReservationStore) Find(key string) (Reservation, bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	reservation, exists := rs.reservations[key]
+	return reservation, exists
+}
+
+// Reserve claims a key for a secret or updates the defaults if the secret already holds it
+// returns whether the key was newly reserved
+func (rs *ReservationStore) Reserve(key, secret string, defaults PipeDefaults) (Reservation, bool, error) {
+	if len(secret) == 0 {
+		return Reservation{}, false, ErrSecretRequired
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	reservation, exists := rs.reservations[key]
+	if exists && !reservation.Allows(secret) {
+		return Reservation{}, false, ErrKeyReserved
+	}
+	if !exists {
+		reservation = Reservation{
+			Key:     key,
+			Secret:  hashSecret(key, secret),
+			Created: time.Now(),
+		}
+	}
+	reservation.Defaults = defaults
+	rs.reservations[key] = reservation
+	return reservation, !exists, rs.save()
+}
+
+// Release frees a key so that anyone can use it again
+func (rs *ReservationStore) Release(key, secret string) error {
+	if len(secret) == 0 {
+		return ErrSecretRequired
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	reservation, exists := rs.reservations[key]
+	if !exists {
+		return ErrNotReserved
+	}
+	if !reservation.Allows(secret) {
+		return ErrKeyReserved
+	}
+	delete(rs.reservations, key)
+	return rs.save()
+}
+
+// write the reservations to a temporary file and move it into place so a crash can't truncate them
+func (rs *ReservationStore) save() error {
+	if len(rs.path) == 0 {
+		return nil
+	}
+	contents, err := json.MarshalIndent(rs.reservations, "", "  ")
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(rs.path), ".reservations")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(contents); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), rs.path)
+}
+
+// LoadReservations reads the reserved keys from a json file
+// an empty path keeps the reservations in memory only
+func LoadReservations(path string) (*ReservationStore, error) {
+	rs := &ReservationStore{
+		path:         path,
+		reservations: make(map[string]Reservation),
+	}
+	if len(path) == 0 {
+		return rs, nil
+	}
+	contents, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return rs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(contents, &rs.reservations); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+// applyDefaults uses the mode of a reserved key if the request didn't ask for one
+func (p *params) applyDefaults(d PipeDefaults, formatSet bool) {
+	if len(p.modes()) > 0 {
+		return
+	}
+	switch d.Mode {
+	case "fail":
+		p.failure = true
+	case "block":
+		p.block = true
+	case "rpc":
+		p.rpc = true
+	case "broadcast":
+		p.broadcast = true
+	case "tty":
+		p.tty = true
+		if !formatSet && p.wantsJSON {
+			p.format = "asciicast"
+		}
+	case "interactive":
+		p.interactive = true
+		if !formatSet {
+			p.format = "interactive"
+		}
+	}
+}
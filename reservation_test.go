@@ -0,0 +1,70 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestReservationStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reservations.json")
+	rs, err := LoadReservations(path)
+	if err != nil {
+		t.Fatalf("Error loading reservations: %s", err.Error())
+	}
+
+	if _, _, err := rs.Reserve("deploys", "", PipeDefaults{}); err != ErrSecretRequired {
+		t.Errorf("Expected secret required: %v", err)
+	}
+	_, created, err := rs.Reserve("deploys", "hunter2", PipeDefaults{Mode: "interactive"})
+	if err != nil || !created {
+		t.Fatalf("Error reserving: %v %v", created, err)
+	}
+	if _, _, err := rs.Reserve("deploys", "wrong", PipeDefaults{}); err != ErrKeyReserved {
+		t.Errorf("Expected key reserved: %v", err)
+	}
+	reservation, created, err := rs.Reserve("deploys", "hunter2", PipeDefaults{Replay: 10})
+	if err != nil || created || reservation.Defaults != (PipeDefaults{Replay: 10}) {
+		t.Errorf("Error updating defaults: %v %v %+v", created, err, reservation)
+	}
+
+	// the reservations survive a restart and the secret isn't stored
+	rs, err = LoadReservations(path)
+	if err != nil {
+		t.Fatalf("Error reloading reservations: %s", err.Error())
+	}
+	reservation, exists := rs.Find("deploys")
+	if !exists || reservation.Defaults.Replay != 10 {
+		t.Fatalf("Reservation not saved: %+v", reservation)
+	}
+	if reservation.Secret == "hunter2" || !reservation.Allows("hunter2") || reservation.Allows("") {
+		t.Errorf("Invalid secret: %s", reservation.Secret)
+	}
+
+	if err := rs.Release("deploys", "wrong"); err != ErrKeyReserved {
+		t.Errorf("Expected key reserved: %v", err)
+	}
+	if err := rs.Release("deploys", "hunter2"); err != nil {
+		t.Errorf("Error releasing: %s", err.Error())
+	}
+	if err := rs.Release("deploys", "hunter2"); err != ErrNotReserved {
+		t.Errorf("Expected not reserved: %v", err)
+	}
+	rs, _ = LoadReservations(path)
+	if _, exists := rs.Find("deploys"); exists {
+		t.Errorf("Release not saved")
+	}
+}
+
+func TestApplyDefaults(t *testing.T) {
+	p := params{format: "raw"}
+	p.applyDefaults(PipeDefaults{Mode: "interactive"}, false)
+	if !p.interactive || p.format != "interactive" {
+		t.Errorf("Default mode not applied: %+v", p)
+	}
+
+	p = params{format: "raw", block: true}
+	p.applyDefaults(PipeDefaults{Mode: "interactive"}, false)
+	if p.interactive || p.format != "raw" {
+		t.Errorf("Requested mode overridden: %+v", p)
+	}
+}
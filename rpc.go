@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// how long a request waits to be read and then replied to when the operator doesn't set -rpctimeout
+const defaultRPCTimeout = 30 * time.Second
+
+// the size of the correlation ids for requests
+const rpcIDSize = 16
+
+var (
+	// ErrNoResponders is returned when a request is sent to a key with no one listening
+	ErrNoResponders = errors.New("no responders available")
+	// ErrNoCall is returned when replying to a request that isn't waiting for a reply
+	ErrNoCall = errors.New("no request waiting for a reply")
+)
+
+// RPCCall is a request from a sender waiting for a responder to reply
+type RPCCall struct {
+	ID          string
+	Key         string
+	Body        io.Reader
+	ContentType string
+	// the result of streaming the request body to the responder
+	delivered chan error
+	// the reply is handed over when the responder posts it
+	reply chan *RPCReply
+	// closed once the sender has stopped waiting
+	finished chan bool
+}
+
+// Delivered reports that the responder has read the whole request (or failed to)
+func (c *RPCCall) Delivered(err error) {
+	c.delivered <- err
+}
+
+// RPCReply is a responder's reply being streamed back to the sender
+type RPCReply struct {
+	Body        io.Reader
+	ContentType string
+	// the result of streaming the reply to the sender
+	done chan error
+}
+
+// RPCBroker matches requests with the responders listening on a key
+// each request goes to exactly one responder - the one that has waited longest
+type RPCBroker struct {
+	mu sync.Mutex
+	// responders waiting for a request by key
+	listeners map[string][]chan *RPCCall
+	// requests waiting for a reply by id
+	calls map[string]*RPCCall
+}
+
+// Listen waits for the next request on a key
+// Unlisten must be called if the responder gives up before a request arrives
+func (b *RPCBroker) Listen(key string) chan *RPCCall {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	listener := make(chan *RPCCall, 1)
+	b.listeners[key] = append(b.listeners[key], listener)
+	return listener
+}
+
+// Unlisten stops waiting for requests
+// returns a request that was handed over in the meantime (or nil)
+func (b *RPCBroker) Unlisten(key string, listener chan *RPCCall) *RPCCall {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	listeners := b.listeners[key]
+	for i, l := range listeners {
+		if l == listener {
+			b.listeners[key] = append(listeners[:i:i], listeners[i+1:]...)
+			break
+		}
+	}
+	if len(b.listeners[key]) == 0 {
+		delete(b.listeners, key)
+	}
+	select {
+	case call := <-listener:
+		return call
+	default:
+		return nil
+	}
+}
+
+// Call hands a request to the responder that has been waiting longest
+func (b *RPCBroker) Call(key string, body io.Reader, contentType string) (*RPCCall, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	listeners := b.listeners[key]
+	if len(listeners) == 0 {
+		return nil, ErrNoResponders
+	}
+	call := &RPCCall{
+		ID:          string(randKey(rpcIDSize)),
+		Key:         key,
+		Body:        body,
+		ContentType: contentType,
+		delivered:   make(chan error, 1),
+		reply:       make(chan *RPCReply, 1),
+		finished:    make(chan bool),
+	}
+	b.calls[call.ID] = call
+	if len(listeners) == 1 {
+		delete(b.listeners, key)
+	} else {
+		b.listeners[key] = listeners[1:]
+	}
+	listeners[0] <- call
+	return call, nil
+}
+
+// Reply hands a reply to the request with the id - each request can only be replied to once
+func (b *RPCBroker) Reply(key, id string, reply *RPCReply) (*RPCCall, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	call, exists := b.calls[id]
+	if !exists || call.Key != key {
+		return nil, ErrNoCall
+	}
+	delete(b.calls, id)
+	reply.done = make(chan error, 1)
+	call.reply <- reply
+	return call, nil
+}
+
+// Finish is called when the sender stops waiting - late replies are refused
+func (b *RPCBroker) Finish(call *RPCCall) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.calls, call.ID)
+	close(call.finished)
+}
+
+// Responders returns the number of responders waiting on a key
+func (b *RPCBroker) Responders(key string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.listeners[key])
+}
+
+// MakeRPCBroker creates a broker with no responders
+func MakeRPCBroker() *RPCBroker {
+	return &RPCBroker{
+		listeners: make(map[string][]chan *RPCCall),
+		calls:     make(map[string]*RPCCall),
+	}
+}
+
+// flushWriter flushes every write so that a reply is streamed as it arrives
+type flushWriter struct {
+	writer  io.Writer
+	flusher http.Flusher
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.writer.Write(p)
+	if f.flusher != nil {
+		f.flusher.Flush()
+	}
+	return n, err
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRPCBroker(t *testing.T) {
+	broker := MakeRPCBroker()
+	if _, err := broker.Call("key", strings.NewReader("request"), ""); err != ErrNoResponders {
+		t.Errorf("Expected no responders: %v", err)
+	}
+
+	first := broker.Listen("key")
+	second := broker.Listen("key")
+	if broker.Responders("key") != 2 {
+		t.Errorf("Invalid responder count: %d", broker.Responders("key"))
+	}
+
+	// the responder that has waited longest gets the request
+	call, err := broker.Call("key", strings.NewReader("request"), "text/plain")
+	if err != nil {
+		t.Fatalf("Error calling: %s", err.Error())
+	}
+	if received := <-first; received != call || len(call.ID) != rpcIDSize {
+		t.Errorf("Invalid call delivered: %+v", received)
+	}
+	if broker.Responders("key") != 1 {
+		t.Errorf("Invalid responder count: %d", broker.Responders("key"))
+	}
+
+	if _, err := broker.Reply("other", call.ID, &RPCReply{}); err != ErrNoCall {
+		t.Errorf("Expected the key to be checked: %v", err)
+	}
+	if _, err := broker.Reply("key", call.ID, &RPCReply{}); err != nil {
+		t.Errorf("Error replying: %s", err.Error())
+	}
+	if _, err := broker.Reply("key", call.ID, &RPCReply{}); err != ErrNoCall {
+		t.Errorf("Expected only one reply: %v", err)
+	}
+	broker.Finish(call)
+
+	// a request handed over as the responder leaves is returned so it can be failed
+	call, _ = broker.Call("key", strings.NewReader("request"), "")
+	if handed := broker.Unlisten("key", second); handed != call {
+		t.Errorf("Expected the handed over call: %v", handed)
+	}
+	if broker.Responders("key") != 0 {
+		t.Errorf("Invalid responder count: %d", broker.Responders("key"))
+	}
+}
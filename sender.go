@@ -2,6 +2,7 @@ package main
 
 import (
 	"io"
+	"time"
 )
 
 // Sender holds the information for a single sender
@@ -9,6 +10,12 @@ type Sender struct {
 	id       int
 	username string
 	pipe     *Pipe
+	// receivers that have been sent data by this sender
+	reached *reach
+	// splits resize events out of a shared terminal (nil unless the sender is in tty mode)
+	tty *TerminalParser
+	// the sender owns the pipe so its messages are marked and it can moderate the pipe
+	owner bool
 }
 
 // Username returns the username supplied by the sender (or client <id> if none was supplied)
@@ -18,10 +25,49 @@ func (s Sender) Username() string {
 
 // Write the buffer to all registered receivers
 func (s Sender) Write(buffer []byte) (int, error) {
-	return s.pipe.Write(Message{
-		fromID:   s.id,
-		fromUser: s.Username(),
-		buffer:   buffer})
+	// the owner moderates the pipe by typing commands (/kick bob)
+	if s.owner {
+		if action, ok := parseAction(buffer); ok {
+			s.pipe.Moderate(action, s.id, s.Username())
+			return len(buffer), nil
+		}
+	}
+	if s.pipe.Kicked(s.Username()) {
+		return len(buffer), nil
+	}
+	if s.pipe.Muted(s.Username()) {
+		s.pipe.tell(s.id, Message{
+			fromID:   s.id,
+			fromUser: s.Username(),
+			buffer:   []byte("muted by the owner, your message was not sent\n"),
+			system:   true})
+		return len(buffer), nil
+	}
+	if s.tty != nil {
+		for _, m := range s.tty.Split(buffer) {
+			s.write(m)
+		}
+		return len(buffer), nil
+	}
+	return s.write(Message{buffer: buffer})
+}
+
+// write a message from this sender to the pipe
+func (s Sender) write(m Message) (int, error) {
+	m.fromID = s.id
+	m.fromUser = s.Username()
+	m.owner = s.owner
+	n, err := s.pipe.Write(m)
+	// the receivers only need to be collected again when one has been added
+	if joined := s.pipe.Joined(); joined != s.reached.joined {
+		s.reached.joined = joined
+		for _, receiver := range s.pipe.Receivers() {
+			if receiver.ID() != s.id {
+				s.reached.receivers[receiver] = true
+			}
+		}
+	}
+	return n, err
 }
 
 // Close all of the registered receivers
@@ -30,14 +76,56 @@ func (s Sender) Close() error {
 }
 
 // Copy transfers bytes from the reader to the attached pipe
-func (s Sender) Copy(reader io.Reader) {
+// returns a summary of the delivery once the receivers have been closed
+func (s Sender) Copy(reader io.Reader) (Receipt, error) {
+	start := time.Now()
+
 	// copy the body to any listening receivers (see Receivers.Write)
-	_, err := io.Copy(s, reader)
+	written, err := io.Copy(s, reader)
+	receipt := s.receipt(written)
 
 	// if the copy made it all the way to EOF, close the receivers
 	if err == nil {
+		if pending := s.flush(); len(pending) > 0 {
+			s.write(Message{buffer: pending})
+		}
 		s.Close()
 	}
+	receipt.Duration = time.Since(start)
+	return receipt, err
+}
+
+// count the receivers that are still connected and those that left part way through
+func (s Sender) receipt(written int64) Receipt {
+	receipt := Receipt{BytesSent: written}
+	connected := make(map[RecieveWriter]bool)
+	for _, receiver := range s.pipe.Receivers() {
+		if receiver.ID() != s.id {
+			connected[receiver] = true
+			receipt.Delivered++
+		}
+	}
+	for receiver := range s.reached.receivers {
+		if !connected[receiver] {
+			receipt.Disconnected++
+		}
+	}
+	return receipt
+}
+
+// flush returns the partial terminal output held back at the end of the session
+func (s Sender) flush() []byte {
+	if s.tty == nil {
+		return nil
+	}
+	return s.tty.Flush()
+}
+
+// MakeTerminalSender creates a sender sharing a terminal session
+func MakeTerminalSender(p *Pipe, id int, username string) Sender {
+	sender := MakeSender(p, id, username)
+	sender.tty = &TerminalParser{}
+	return sender
 }
 
 // MakeSender creates a new sender
@@ -46,5 +134,13 @@ func MakeSender(p *Pipe, id int, username string) Sender {
 		pipe:     p,
 		id:       id,
 		username: username,
+		reached:  &reach{receivers: make(map[RecieveWriter]bool)},
 	}
 }
+
+// reach is the receivers a sender has sent data to
+type reach struct {
+	receivers map[RecieveWriter]bool
+	// the number of receivers the pipe had added when they were last collected
+	joined int
+}
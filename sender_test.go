@@ -1,6 +1,7 @@
 package main
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -66,3 +67,37 @@ func TestSenderClose(t *testing.T) {
 		}
 	}
 }
+
+func TestSenderReceipt(t *testing.T) {
+	handler := &TestHandler{}
+	pipe := MakePipe(handler)
+	receivers := [3]*TestReceiver{
+		&TestReceiver{},
+		&TestReceiver{},
+		&TestReceiver{},
+	}
+	for _, r := range receivers {
+		pipe.AddReceiver(r)
+	}
+	sender := MakeSender(pipe, 1, "")
+
+	sender.Write([]byte("test input"))
+	pipe.RemoveReceiver(receivers[0])
+	// a receiver that joins part way through is counted once it is sent data
+	late := &TestReceiver{}
+	pipe.AddReceiver(late)
+	receipt, err := sender.Copy(strings.NewReader("more input"))
+
+	if err != nil {
+		t.Errorf("Error copying to pipe: %s", err.Error())
+	}
+	if receipt.BytesSent != int64(len("more input")) {
+		t.Errorf("Invalid receipt bytes: %d %d", len("more input"), receipt.BytesSent)
+	}
+	if receipt.Delivered != 3 {
+		t.Errorf("Invalid receipt delivered count: %d %d", 3, receipt.Delivered)
+	}
+	if receipt.Disconnected != 1 {
+		t.Errorf("Invalid receipt disconnected count: %d %d", 1, receipt.Disconnected)
+	}
+}
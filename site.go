@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// the modes that the operator can enable (all are enabled by default)
+var allModes = []string{"fail", "block", "interactive", "file", "rpc", "tty", "broadcast"}
+
+// Site describes this instance of the server to the templates
+type Site struct {
+	Name    string   // the name of the instance (-name)
+	Contact string   // how to reach the operator (-contact)
+	Base    string   // the base url of the service
+	Limits  Limits   // the default limits on every pipe
+	Modes   []string // the modes that are enabled
+}
+
+// ModeEnabled returns whether a mode can be used on this instance
+func (s Site) ModeEnabled(mode string) bool {
+	for _, enabled := range s.Modes {
+		if enabled == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// EnabledModes lists the enabled modes for the templates
+func (s Site) EnabledModes() string {
+	return strings.Join(s.Modes, ", ")
+}
+
+// parseModes reads a comma separated list of modes (-modes fail,block)
+func parseModes(list string) ([]string, error) {
+	if len(strings.TrimSpace(list)) == 0 {
+		return allModes, nil
+	}
+	var modes []string
+	for _, mode := range strings.Split(list, ",") {
+		mode = strings.TrimSpace(mode)
+		known := false
+		for _, m := range allModes {
+			known = known || m == mode
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown mode: %s", mode)
+		}
+		modes = append(modes, mode)
+	}
+	return modes, nil
+}
+
+// modes returns the modes used by a request
+func (p params) modes() []string {
+	var modes []string
+	if p.failure {
+		modes = append(modes, "fail")
+	}
+	if p.block {
+		modes = append(modes, "block")
+	}
+	if p.interactive {
+		modes = append(modes, "interactive")
+	}
+	if p.file {
+		modes = append(modes, "file")
+	}
+	if p.rpc {
+		modes = append(modes, "rpc")
+	}
+	if p.tty {
+		modes = append(modes, "tty")
+	}
+	if p.broadcast {
+		modes = append(modes, "broadcast")
+	}
+	return modes
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseModes(t *testing.T) {
+	modes, err := parseModes("")
+	if err != nil || strings.Join(modes, ",") != strings.Join(allModes, ",") {
+		t.Errorf("Invalid default modes: %v %v", modes, err)
+	}
+	modes, err = parseModes("fail, file")
+	if err != nil || strings.Join(modes, ",") != "fail,file" {
+		t.Errorf("Invalid modes: %v %v", modes, err)
+	}
+	if _, err := parseModes("fail,unknown"); err == nil {
+		t.Error("Expected error for unknown mode")
+	}
+
+	site := Site{Modes: modes}
+	if !site.ModeEnabled("file") || site.ModeEnabled("interactive") {
+		t.Errorf("Invalid enabled modes: %s", site.EnabledModes())
+	}
+}
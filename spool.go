@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// ErrSpoolDiscarded is returned when reading or writing a spool that has been thrown away
+var ErrSpoolDiscarded = errors.New("spool discarded")
+
+// Spool is an append-only temporary file that buffers a file transfer
+// so that the sender and receiver can each resume from a byte offset
+type Spool struct {
+	mu        sync.Mutex
+	file      *os.File
+	size      int64
+	finished  bool
+	discarded bool
+	// closed and replaced whenever the spool changes so that readers can wait for more data
+	changed chan bool
+}
+
+// Write appends the buffer to the end of the spool
+func (s *Spool) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.discarded {
+		return 0, ErrSpoolDiscarded
+	}
+	n, err := s.file.WriteAt(p, s.size)
+	s.size += int64(n)
+	s.notify()
+	return n, err
+}
+
+// Size returns the number of bytes in the spool
+func (s *Spool) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// Finish marks the spool as complete - readers reaching the end will get EOF
+func (s *Spool) Finish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.finished = true
+	s.notify()
+}
+
+// Finished returns whether the sender has uploaded all of the data
+func (s *Spool) Finished() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.finished
+}
+
+// Discard closes and removes the spool file - any readers will fail
+func (s *Spool) Discard() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.discarded {
+		return nil
+	}
+	s.discarded = true
+	s.notify()
+	s.file.Close()
+	return os.Remove(s.file.Name())
+}
+
+// wake up any waiting readers (must be called with the lock held)
+func (s *Spool) notify() {
+	close(s.changed)
+	s.changed = make(chan bool)
+}
+
+// NewReader reads the spool from the offset
+// it waits for more data until the spool is finished or the context is done
+func (s *Spool) NewReader(ctx context.Context, offset int64) *SpoolReader {
+	return &SpoolReader{spool: s, ctx: ctx, offset: offset}
+}
+
+// SpoolReader follows a spool as it is written
+type SpoolReader struct {
+	spool  *Spool
+	ctx    context.Context
+	offset int64
+}
+
+// Read the next available bytes - blocks until there is data to return
+func (r *SpoolReader) Read(p []byte) (int, error) {
+	for {
+		r.spool.mu.Lock()
+		size, finished, discarded, changed := r.spool.size, r.spool.finished, r.spool.discarded, r.spool.changed
+		r.spool.mu.Unlock()
+
+		if discarded {
+			return 0, ErrSpoolDiscarded
+		}
+		if r.offset < size {
+			if remaining := size - r.offset; int64(len(p)) > remaining {
+				p = p[:remaining]
+			}
+			n, err := r.spool.file.ReadAt(p, r.offset)
+			r.offset += int64(n)
+			if err == io.EOF && n > 0 {
+				err = nil
+			}
+			return n, err
+		}
+		if finished {
+			return 0, io.EOF
+		}
+		select {
+		case <-r.ctx.Done():
+			return 0, r.ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// Offset returns the position of the next byte to be read
+func (r *SpoolReader) Offset() int64 {
+	return r.offset
+}
+
+// MakeSpool creates an empty spool file in the directory (os.TempDir if empty)
+func MakeSpool(dir string) (*Spool, error) {
+	file, err := os.CreateTemp(dir, "pipe-to-me-")
+	if err != nil {
+		return nil, err
+	}
+	return &Spool{
+		file:    file,
+		changed: make(chan bool),
+	}, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestSpoolReader(t *testing.T) {
+	spool, err := MakeSpool("")
+	if err != nil {
+		t.Fatalf("Error creating spool: %s", err.Error())
+	}
+	defer spool.Discard()
+
+	spool.Write([]byte("test "))
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		spool.Write([]byte("input"))
+		spool.Finish()
+	}()
+
+	reader := spool.NewReader(context.Background(), 2)
+	output, err := io.ReadAll(reader)
+	if err != nil {
+		t.Errorf("Error reading spool: %s", err.Error())
+	}
+	if string(output) != "st input" {
+		t.Errorf("Invalid spool contents: %s %s", "st input", string(output))
+	}
+	if reader.Offset() != spool.Size() {
+		t.Errorf("Invalid reader offset: %d %d", spool.Size(), reader.Offset())
+	}
+}
+
+func TestSpoolDiscard(t *testing.T) {
+	spool, err := MakeSpool("")
+	if err != nil {
+		t.Fatalf("Error creating spool: %s", err.Error())
+	}
+
+	reader := spool.NewReader(context.Background(), 0)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		spool.Discard()
+	}()
+
+	buffer := make([]byte, 10)
+	if _, err := reader.Read(buffer); err != ErrSpoolDiscarded {
+		t.Errorf("Reading a discarded spool should fail: %v", err)
+	}
+	if _, err := spool.Write(buffer); err != ErrSpoolDiscarded {
+		t.Errorf("Writing a discarded spool should fail: %v", err)
+	}
+}
+
+func TestSpoolReaderCancel(t *testing.T) {
+	spool, err := MakeSpool("")
+	if err != nil {
+		t.Fatalf("Error creating spool: %s", err.Error())
+	}
+	defer spool.Discard()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := spool.NewReader(ctx, 0).Read(make([]byte, 10)); err == nil || err == io.EOF {
+		t.Errorf("Reading with a cancelled context should fail: %v", err)
+	}
+}
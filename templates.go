@@ -1,91 +1,169 @@
 package main
 
-import "text/template"
-
-func templates() *template.Template {
-	tmpl := template.Must(template.New("").Parse(""))
-
-	template.Must(tmpl.New("home").Parse(`pipeto.me(1)                     PIPE TO ME                         pipeto.me(1)
-
-NAME
-    pipeto.me: streaming data over http
-
-SYNOPSIS
-    Randomly generated pipe address:                  {{ .URL }}
-
-EXAMPLES
-    Pipe example:
-
-    (chrome/firefox): {{ .URL }}
-    (terminal2)$ echo hello world | curl -T- {{ .URL }}
-
-    Input example:
-
-    (chrome/firefox): {{ .URL }}
-    (terminal)$ curl -T- {{ .URL }}
-                hello world<enter>
-
-    File transfer example:
-
-    (terminal1)$ curl {{ .URL }} > output.txt
-    (terminal2)$ cat input.txt | curl -T- {{ .URL }}
-
-    Chat example(curl>=7.68):
-
-    (terminal1)$ curl -T. -u user1: {{ .URL }}?mode=interactive
-    (terminal2)$ curl -T. -u user2: {{ .URL }}?mode=interactive
-                 hello world<enter>
-
-DESCRIPTION
-    Data is not buffered or stored in any way.
-    Data is not retrievable after it has been delivered.
-
-    Maximum upload size: {{ .MaxUploadMb }} MB
-    Not allowed: anything illegal, malicious, inappropriate, etc.
-
-    This is a personal project and makes no guarantees on:
-    reliability, performance, privacy, etc.
-
-    Default Mode:
-
-    If data is sent to the pipe when no receivers are listening, 
-    it will be dropped and is not retrievable.
-
-    Fail Mode: 
-
-    $ curl -T- {{ .URL }}?mode=fail
-    In this mode, a send request will fail if no receivers are listening.
-
-    Block Mode:
-
-    $ curl -T- --expect100-timeout 86400 {{ .URL }}?mode=block
-    In this mode, a send request will wait to send data until a receiver connects.
+import (
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+)
+
+// the default templates - name.txt is rendered for curl and name.html for browsers
+//
+//go:embed templates
+var templateFiles embed.FS
+
+// Templates holds the text and html versions of the pages
+// overrides from a directory are reloaded when they change
+type Templates struct {
+	dir string
+	// the modification times of the override files when they were last loaded
+	version string
+
+	mu   sync.RWMutex
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
 
-    Interactive Mode:
+// Execute renders the text or html version of a page
+func (t *Templates) Execute(w io.Writer, name string, html bool, data interface{}) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if html && t.html.Lookup(name) != nil {
+		return t.html.ExecuteTemplate(w, name, data)
+	}
+	return t.text.ExecuteTemplate(w, name, data)
+}
 
-    $ curl -T. -u <username>: {{ .URL }}?mode=interactive
-    In this mode the system will append the username to messages.
-    The system will also send connected and disconnected notifications.
+// Validate renders each page with sample data to find references to unknown fields
+func (t *Templates) Validate(samples map[string]interface{}) error {
+	for name, data := range samples {
+		for _, html := range []bool{false, true} {
+			if err := t.Execute(io.Discard, name, html, data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
 
-SEE ALSO
-    Demo: https://raw.githubusercontent.com/jpschroeder/pipe-to-me/master/demo.gif
-    Source: https://github.com/jpschroeder/pipe-to-me
-	`))
+// Reload parses the templates again if the override directory has changed
+// the current templates are kept if the new ones fail to parse or validate
+func (t *Templates) Reload(samples map[string]interface{}) (bool, error) {
+	version := dirVersion(t.dir)
+	if version == t.version {
+		return false, nil
+	}
+	// don't retry a broken template until it changes again
+	t.version = version
+	next, err := loadTemplates(t.dir)
+	if err != nil {
+		return false, err
+	}
+	if err := next.Validate(samples); err != nil {
+		return false, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.text, t.html = next.text, next.html
+	return true, nil
+}
 
-	template.Must(tmpl.New("stats").Parse(`pipeto.me(1)                     PIPE TO ME                         pipeto.me(1)
+// Watch reloads the templates whenever the override directory changes
+func (t *Templates) Watch(interval time.Duration, samples map[string]interface{}) {
+	if len(t.dir) == 0 {
+		return
+	}
+	for range time.Tick(interval) {
+		reloaded, err := t.Reload(samples)
+		if err != nil {
+			log.Println("Error reloading templates:", err)
+		} else if reloaded {
+			log.Println("Reloaded templates from", t.dir)
+		}
+	}
+}
 
-STATISTICS
+// loadTemplates parses the embedded templates
+// a file with the same name in dir (if set) is used instead of the embedded one
+func loadTemplates(dir string) (*Templates, error) {
+	t := &Templates{
+		dir:     dir,
+		version: dirVersion(dir),
+		text:    texttemplate.New(""),
+		html:    htmltemplate.New(""),
+	}
+	files, err := fs.ReadDir(templateFiles, "templates")
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		contents, err := readTemplate(dir, file.Name())
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimSuffix(file.Name(), path.Ext(file.Name()))
+		if path.Ext(file.Name()) == ".html" {
+			_, err = t.html.New(name).Parse(contents)
+		} else {
+			_, err = t.text.New(name).Parse(contents)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
 
-    Connected Pipes:        {{ .Active.PipeCount }}
-    Connected Receivers:    {{ .Active.ReceiverCount }}
-    Connected Senders:      {{ .Active.SenderCount }}
-    Connected Sent:         {{ .Active.BytesSent }} ({{ .Active.MegaBytesSent }} MB)
+// read a template from the override directory or fall back to the embedded one
+func readTemplate(dir, filename string) (string, error) {
+	if len(dir) > 0 {
+		contents, err := os.ReadFile(filepath.Join(dir, filename))
+		if err == nil {
+			return string(contents), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	contents, err := templateFiles.ReadFile("templates/" + filename)
+	return string(contents), err
+}
 
-    Total Pipes:            {{ .Global.PipeCount }}
-    Total Receivers:        {{ .Global.ReceiverCount }}
-    Total Senders:          {{ .Global.SenderCount }}
-    Total Sent:             {{ .Global.BytesSent }} ({{ .Global.MegaBytesSent }} MB)
-	`))
+// dirVersion summarizes the names, sizes and modification times of the files in a directory
+func dirVersion(dir string) string {
+	if len(dir) == 0 {
+		return ""
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err.Error()
+	}
+	var version strings.Builder
+	for _, entry := range entries {
+		// a file removed since the directory was read changes the version anyway
+		file, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&version, "%s %d %d\n", file.Name(), file.Size(), file.ModTime().UnixNano())
+	}
+	return version.String()
+}
 
-	return tmpl
+// templates returns the embedded templates
+func templates() *Templates {
+	t, err := loadTemplates("")
+	if err != nil {
+		panic(err)
+	}
+	return t
 }
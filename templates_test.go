@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadTemplates(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "home.txt"), []byte("custom {{ .URL }}"), 0644)
+
+	tmpl, err := loadTemplates(dir)
+	if err != nil {
+		t.Fatalf("Error loading templates: %s", err.Error())
+	}
+	data := homeData{URL: "http://localhost/abc"}
+
+	var buf bytes.Buffer
+	tmpl.Execute(&buf, "home", false, data)
+	if buf.String() != "custom http://localhost/abc" {
+		t.Errorf("Invalid override: %s", buf.String())
+	}
+
+	// files that aren't overridden use the embedded version
+	buf.Reset()
+	tmpl.Execute(&buf, "home", true, data)
+	if !strings.Contains(buf.String(), "<html>") {
+		t.Errorf("Invalid embedded html: %s", buf.String())
+	}
+
+	os.WriteFile(filepath.Join(dir, "stats.txt"), []byte("{{ .Broken "), 0644)
+	if _, err := loadTemplates(dir); err == nil {
+		t.Error("Expected error for invalid override")
+	}
+}
+
+func TestValidateTemplates(t *testing.T) {
+	if err := templates().Validate(templateSamples); err != nil {
+		t.Errorf("Invalid embedded templates: %s", err.Error())
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "home.html"), []byte("<p>{{ .Unknown }}</p>"), 0644)
+	tmpl, err := loadTemplates(dir)
+	if err != nil {
+		t.Fatalf("Error loading templates: %s", err.Error())
+	}
+	if err := tmpl.Validate(templateSamples); err == nil {
+		t.Error("Expected error for unknown field")
+	}
+}
+
+func TestReloadTemplates(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "stats.txt")
+	os.WriteFile(file, []byte("first"), 0644)
+	tmpl, _ := loadTemplates(dir)
+
+	render := func() string {
+		var buf bytes.Buffer
+		tmpl.Execute(&buf, "stats", false, statsData{})
+		return buf.String()
+	}
+	if reloaded, _ := tmpl.Reload(templateSamples); reloaded || render() != "first" {
+		t.Errorf("Unexpected reload: %t %s", reloaded, render())
+	}
+
+	// make sure the modification time changes
+	modified := time.Now().Add(time.Second)
+	os.WriteFile(file, []byte("second {{ .Name }}"), 0644)
+	os.Chtimes(file, modified, modified)
+	if reloaded, err := tmpl.Reload(templateSamples); !reloaded || err != nil || render() != "second " {
+		t.Errorf("Invalid reload: %t %v %s", reloaded, err, render())
+	}
+
+	// a broken template keeps the last good one
+	modified = modified.Add(time.Second)
+	os.WriteFile(file, []byte("{{ .Unknown }}"), 0644)
+	os.Chtimes(file, modified, modified)
+	if reloaded, err := tmpl.Reload(templateSamples); reloaded || err == nil || render() != "second " {
+		t.Errorf("Invalid broken reload: %t %v %s", reloaded, err, render())
+	}
+}
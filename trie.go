@@ -0,0 +1,148 @@
+package main
+
+import "strings"
+
+// pipeTrie indexes pipes by the segments of their keys (team/builds/linux)
+// wildcard subscriptions are stored under their pattern (team/builds/*)
+// so that the subscriptions for a key can be found without checking every pipe
+type pipeTrie struct {
+	pipe     *Pipe
+	children map[string]*pipeTrie
+}
+
+// Get returns the pipe for a key or nil if it doesn't exist
+func (t *pipeTrie) Get(key string) *Pipe {
+	node := t
+	for _, segment := range strings.Split(key, "/") {
+		node = node.children[segment]
+		if node == nil {
+			return nil
+		}
+	}
+	return node.pipe
+}
+
+// Put stores the pipe for a key
+func (t *pipeTrie) Put(key string, pipe *Pipe) {
+	node := t
+	for _, segment := range strings.Split(key, "/") {
+		child, exists := node.children[segment]
+		if !exists {
+			child = makePipeTrie()
+			node.children[segment] = child
+		}
+		node = child
+	}
+	node.pipe = pipe
+}
+
+// Delete removes the pipe for a key along with any branches left empty
+func (t *pipeTrie) Delete(key string) {
+	t.delete(strings.Split(key, "/"))
+}
+
+// returns whether the node is empty after the delete
+func (t *pipeTrie) delete(segments []string) bool {
+	if len(segments) == 0 {
+		t.pipe = nil
+	} else if child, exists := t.children[segments[0]]; exists && child.delete(segments[1:]) {
+		delete(t.children, segments[0])
+	}
+	return t.pipe == nil && len(t.children) == 0
+}
+
+// Len returns the number of pipes
+func (t *pipeTrie) Len() int {
+	count := 0
+	t.Walk(func(string, *Pipe) { count++ })
+	return count
+}
+
+// Walk calls fn for every pipe and its key
+func (t *pipeTrie) Walk(fn func(key string, pipe *Pipe)) {
+	t.walk("", fn)
+}
+
+func (t *pipeTrie) walk(prefix string, fn func(key string, pipe *Pipe)) {
+	if t.pipe != nil {
+		fn(prefix, t.pipe)
+	}
+	for segment, child := range t.children {
+		key := segment
+		if len(prefix) > 0 {
+			key = prefix + "/" + segment
+		}
+		child.walk(key, fn)
+	}
+}
+
+// Match returns the wildcard pipes with a pattern that matches a key
+// * matches a single segment and ** matches one or more trailing segments
+// the pipe for the key itself is never included even if the key is a pattern
+func (t *pipeTrie) Match(key string) []*Pipe {
+	var matches []*Pipe
+	t.match(strings.Split(key, "/"), false, &matches)
+	exact := t.Get(key)
+	if exact == nil {
+		return matches
+	}
+	subscriptions := matches[:0]
+	for _, pipe := range matches {
+		if pipe != exact {
+			subscriptions = append(subscriptions, pipe)
+		}
+	}
+	return subscriptions
+}
+
+func (t *pipeTrie) match(segments []string, wild bool, matches *[]*Pipe) {
+	if len(segments) == 0 {
+		if wild && t.pipe != nil {
+			*matches = append(*matches, t.pipe)
+		}
+		return
+	}
+	if child, exists := t.children["**"]; exists && child.pipe != nil {
+		*matches = append(*matches, child.pipe)
+	}
+	if child, exists := t.children["*"]; exists {
+		child.match(segments[1:], true, matches)
+	}
+	if child, exists := t.children[segments[0]]; exists {
+		child.match(segments[1:], wild, matches)
+	}
+}
+
+// matchesPattern returns whether a wildcard pattern matches a key the same way Match does
+func matchesPattern(pattern, key string) bool {
+	patternSegments, keySegments := strings.Split(pattern, "/"), strings.Split(key, "/")
+	for i, segment := range patternSegments {
+		if segment == "**" {
+			return len(keySegments) > i
+		}
+		if i >= len(keySegments) || (segment != "*" && segment != keySegments[i]) {
+			return false
+		}
+	}
+	return len(patternSegments) == len(keySegments)
+}
+
+// isPattern returns whether a key contains wildcards
+func isPattern(key string) bool {
+	return strings.Contains(key, "*")
+}
+
+// validPattern returns whether ** is only used as the last segment of a key
+func validPattern(key string) bool {
+	segments := strings.Split(key, "/")
+	for _, segment := range segments[:len(segments)-1] {
+		if segment == "**" {
+			return false
+		}
+	}
+	return true
+}
+
+func makePipeTrie() *pipeTrie {
+	return &pipeTrie{children: make(map[string]*pipeTrie)}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestPipeTrie(t *testing.T) {
+	trie := makePipeTrie()
+	linux := &Pipe{key: "team/builds/linux"}
+	trie.Put(linux.key, linux)
+	trie.Put("flat", &Pipe{key: "flat"})
+
+	if trie.Get("team/builds/linux") != linux || trie.Get("team/builds") != nil || trie.Get("other") != nil {
+		t.Errorf("Invalid lookup")
+	}
+	if trie.Len() != 2 {
+		t.Errorf("Invalid length: %d", trie.Len())
+	}
+
+	var keys []string
+	trie.Walk(func(key string, pipe *Pipe) {
+		keys = append(keys, key)
+	})
+	sort.Strings(keys)
+	if len(keys) != 2 || keys[0] != "flat" || keys[1] != "team/builds/linux" {
+		t.Errorf("Invalid walk: %v", keys)
+	}
+
+	trie.Delete("team/builds/linux")
+	if trie.Get("team/builds/linux") != nil || len(trie.children) != 1 {
+		t.Errorf("Empty branches not removed: %v", trie.children)
+	}
+}
+
+func TestPipeTrieMatch(t *testing.T) {
+	trie := makePipeTrie()
+	patterns := []string{"team/builds/*", "team/**", "*/builds/linux", "**", "team/builds/linux", "other/*"}
+	for _, pattern := range patterns {
+		trie.Put(pattern, &Pipe{key: pattern})
+	}
+
+	tests := map[string][]string{
+		"team/builds/linux": {"**", "*/builds/linux", "team/**", "team/builds/*"},
+		"team/builds":       {"**", "team/**"},
+		"team":              {"**"},
+		"other/x":           {"**", "other/*"},
+		"other/x/y":         {"**"},
+		// a pattern's own pipe doesn't subscribe to itself
+		"team/builds/*": {"**", "team/**"},
+		"other/*":       {"**"},
+	}
+	for key, expected := range tests {
+		var matched []string
+		for _, pipe := range trie.Match(key) {
+			matched = append(matched, pipe.key)
+		}
+		sort.Strings(matched)
+		if len(matched) != len(expected) {
+			t.Errorf("Invalid matches for %s: %v %v", key, expected, matched)
+			continue
+		}
+		for i := range matched {
+			if matched[i] != expected[i] {
+				t.Errorf("Invalid matches for %s: %v %v", key, expected, matched)
+				break
+			}
+		}
+	}
+}
+
+func TestMatchesPattern(t *testing.T) {
+	tests := []struct {
+		pattern, key string
+		expected     bool
+	}{
+		{"team/builds/*", "team/builds/linux", true},
+		{"team/builds/*", "team/builds", false},
+		{"team/builds/*", "team/builds/linux/arm", false},
+		{"team/**", "team/builds/linux", true},
+		{"team/**", "team", false},
+		{"*/builds/linux", "team/builds/linux", true},
+		{"other/*", "team/x", false},
+	}
+	for _, test := range tests {
+		if matchesPattern(test.pattern, test.key) != test.expected {
+			t.Errorf("Invalid match of %s against %s: %v", test.pattern, test.key, test.expected)
+		}
+	}
+}
+
+func TestValidPattern(t *testing.T) {
+	tests := map[string]bool{
+		"team/**":   true,
+		"team/*/x":  true,
+		"**/x":      false,
+		"team/**/x": false,
+	}
+	for pattern, expected := range tests {
+		if validPattern(pattern) != expected {
+			t.Errorf("Invalid pattern check for %s: %v", pattern, expected)
+		}
+	}
+}
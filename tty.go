@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// the content type of asciicast v2 streams (https://docs.asciinema.org/manual/asciicast/v2/)
+const asciicastType = "application/x-asciicast"
+
+// the size of a shared terminal until the sender says otherwise
+var defaultTerminalSize = TerminalSize{Cols: 80, Rows: 24}
+
+// the xterm sequence to resize a terminal: ESC [ 8 ; <rows> ; <cols> t
+var resizeRegex = regexp.MustCompile(`\x1b\[8;(\d{1,4});(\d{1,4})t`)
+
+// the start of a resize sequence that may be finished by the next write
+var partialResizeRegex = regexp.MustCompile(`\x1b(\[(8(;\d{0,4}(;\d{0,4})?)?)?)?$`)
+
+// TerminalSize is the number of columns and rows of a terminal
+type TerminalSize struct {
+	Cols, Rows int
+}
+
+func (t TerminalSize) String() string {
+	return fmt.Sprintf("%dx%d", t.Cols, t.Rows)
+}
+
+// Sequence returns the escape sequence that resizes a terminal to this size
+func (t TerminalSize) Sequence() []byte {
+	return []byte(fmt.Sprintf("\x1b[8;%d;%dt", t.Rows, t.Cols))
+}
+
+// parseTerminalSize reads a size in the form <cols>x<rows> (e.g. 80x24)
+func parseTerminalSize(s string) (TerminalSize, error) {
+	var size TerminalSize
+	if n, err := fmt.Sscanf(s, "%dx%d", &size.Cols, &size.Rows); err != nil || n != 2 {
+		return size, fmt.Errorf("Invalid terminal size (use <cols>x<rows>)")
+	}
+	if size.Cols < 1 || size.Rows < 1 || size.Cols > 9999 || size.Rows > 9999 {
+		return size, fmt.Errorf("Invalid terminal size (use <cols>x<rows>)")
+	}
+	return size, nil
+}
+
+// TerminalParser splits the output of a shared terminal into messages
+// resize sequences become resize messages and a sequence or character split
+// across two writes is held back until the rest arrives
+type TerminalParser struct {
+	pending []byte
+}
+
+// Split returns the output and resize messages in the data
+func (t *TerminalParser) Split(data []byte) []Message {
+	data = append(t.pending, data...)
+	t.pending = nil
+	var messages []Message
+	for {
+		match := resizeRegex.FindSubmatchIndex(data)
+		if match == nil {
+			break
+		}
+		if match[0] > 0 {
+			messages = append(messages, Message{buffer: data[:match[0]]})
+		}
+		rows, _ := strconv.Atoi(string(data[match[2]:match[3]]))
+		cols, _ := strconv.Atoi(string(data[match[4]:match[5]]))
+		if size := (TerminalSize{Cols: cols, Rows: rows}); cols > 0 && rows > 0 {
+			messages = append(messages, Message{buffer: data[match[0]:match[1]], size: &size})
+		}
+		data = data[match[1]:]
+	}
+
+	end := len(data)
+	if loc := partialResizeRegex.FindIndex(data); loc != nil {
+		end = loc[0]
+	} else {
+		// the start of the last character if it isn't complete
+		for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+			if utf8.RuneStart(data[i]) {
+				if !utf8.FullRune(data[i:]) {
+					end = i
+				}
+				break
+			}
+		}
+	}
+	if end < len(data) {
+		t.pending = append([]byte(nil), data[end:]...)
+	}
+	if end > 0 {
+		messages = append(messages, Message{buffer: data[:end]})
+	}
+	return messages
+}
+
+// Flush returns whatever was held back once the terminal session ends
+func (t *TerminalParser) Flush() []byte {
+	pending := t.pending
+	t.pending = nil
+	return pending
+}
+
+// AsciicastFormatter writes a terminal session as asciicast v2 events
+// the header is written by the receiver when it connects (see asciicastHeader)
+type AsciicastFormatter struct {
+	// when the receiver connected - event times are relative to it
+	start time.Time
+}
+
+// Format the message as an output or resize event
+func (f AsciicastFormatter) Format(m Message, receiver RecieveWriter) []byte {
+	if m.system || m.fromID == receiver.ID() {
+		return []byte{}
+	}
+	elapsed := time.Since(f.start)
+	if m.size != nil {
+		return asciicastEvent(elapsed, "r", m.size.String())
+	}
+	return asciicastEvent(elapsed, "o", string(m.buffer))
+}
+
+// Heartbeat is never sent - any extra data would corrupt the asciicast
+func (AsciicastFormatter) Heartbeat(data []byte) []byte {
+	return nil
+}
+
+// ContentType of the formatted stream
+func (AsciicastFormatter) ContentType() string {
+	return asciicastType
+}
+
+// the first line of an asciicast
+type asciicastHeaderLine struct {
+	Version       int     `json:"version"`
+	Width         int     `json:"width"`
+	Height        int     `json:"height"`
+	Timestamp     int64   `json:"timestamp"`
+	IdleTimeLimit float64 `json:"idle_time_limit,omitempty"`
+	Title         string  `json:"title,omitempty"`
+}
+
+// asciicastHeader describes a terminal session that started at start
+func asciicastHeader(size TerminalSize, start time.Time, title string, idle time.Duration) []byte {
+	line, _ := json.Marshal(asciicastHeaderLine{
+		Version:       2,
+		Width:         size.Cols,
+		Height:        size.Rows,
+		Timestamp:     start.Unix(),
+		IdleTimeLimit: idle.Seconds(),
+		Title:         title,
+	})
+	return append(line, '\n')
+}
+
+// asciicastEvent is a single line of an asciicast: [<seconds>, <code>, <data>]
+func asciicastEvent(elapsed time.Duration, code, data string) []byte {
+	seconds := math.Round(elapsed.Seconds()*1e6) / 1e6
+	line, _ := json.Marshal([]interface{}{seconds, code, data})
+	return append(line, '\n')
+}
+
+// WriteAsciicast converts a recording to an asciicast sped up by speed
+// the terminal size set before any output is used for the header
+func WriteAsciicast(w io.Writer, r io.Reader, title string, speed float64) error {
+	size := defaultTerminalSize
+	var start time.Time
+	started := false
+	write := func(elapsed time.Duration, code, data string) error {
+		if !started {
+			started = true
+			idle := time.Duration(float64(maxReplayPause) / speed)
+			if _, err := w.Write(asciicastHeader(size, start, title, idle)); err != nil {
+				return err
+			}
+		}
+		_, err := w.Write(asciicastEvent(time.Duration(float64(elapsed)/speed), code, data))
+		return err
+	}
+	err := readRecording(r, func(m RecordedMessage) error {
+		if m.System {
+			return nil
+		}
+		if start.IsZero() {
+			start = m.Time
+		}
+		if len(m.Size) > 0 {
+			resize, err := parseTerminalSize(m.Size)
+			if err != nil {
+				return nil
+			}
+			if !started {
+				size = resize
+				return nil
+			}
+			return write(m.Time.Sub(start), "r", resize.String())
+		}
+		return write(m.Time.Sub(start), "o", string(m.Data))
+	})
+	if err == nil && !started {
+		_, err = w.Write(asciicastHeader(size, time.Now(), title, 0))
+	}
+	return err
+}
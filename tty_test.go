@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTerminalSize(t *testing.T) {
+	size, err := parseTerminalSize("120x40")
+	if err != nil || size.Cols != 120 || size.Rows != 40 || size.String() != "120x40" {
+		t.Errorf("Invalid size: %v %v", size, err)
+	}
+	if string(size.Sequence()) != "\x1b[8;40;120t" {
+		t.Errorf("Invalid resize sequence: %q", size.Sequence())
+	}
+	for _, invalid := range []string{"", "80", "0x24", "80x-1", "x24", "100000x24"} {
+		if _, err := parseTerminalSize(invalid); err == nil {
+			t.Errorf("Expected an invalid size: %q", invalid)
+		}
+	}
+}
+
+func TestTerminalParser(t *testing.T) {
+	var parser TerminalParser
+	var out, sizes []string
+	split := func(data string) {
+		for _, m := range parser.Split([]byte(data)) {
+			if m.size != nil {
+				sizes = append(sizes, m.size.String())
+			}
+			out = append(out, string(m.buffer))
+		}
+	}
+	// a resize sequence and a character split across writes are put back together
+	split("one\x1b[8;4")
+	split("0;120ttwo\xe2\x82")
+	split("\xac\x1b")
+	split("[1mbold")
+	split("\x1b[8")
+	out = append(out, string(parser.Flush()))
+
+	expected := []string{"one", "\x1b[8;40;120t", "two", "€", "\x1b[1mbold", "\x1b[8"}
+	if strings.Join(out, "|") != strings.Join(expected, "|") {
+		t.Errorf("Invalid terminal output: %q", out)
+	}
+	if len(sizes) != 1 || sizes[0] != "120x40" {
+		t.Errorf("Invalid resizes: %v", sizes)
+	}
+}
+
+func TestAsciicastFormatter(t *testing.T) {
+	f := AsciicastFormatter{start: time.Now().Add(-1500 * time.Millisecond)}
+	receiver := MakeReceiver(nil, nil, 1, f, "")
+
+	var event []interface{}
+	json.Unmarshal(f.Format(Message{fromID: 2, buffer: []byte("ls\r\n")}, receiver), &event)
+	if len(event) != 3 || event[0].(float64) < 1.5 || event[1] != "o" || event[2] != "ls\r\n" {
+		t.Errorf("Invalid output event: %v", event)
+	}
+	size := TerminalSize{Cols: 100, Rows: 30}
+	json.Unmarshal(f.Format(Message{fromID: 2, buffer: size.Sequence(), size: &size}, receiver), &event)
+	if event[1] != "r" || event[2] != "100x30" {
+		t.Errorf("Invalid resize event: %v", event)
+	}
+	if len(f.Format(Message{fromID: 1, buffer: []byte("own")}, receiver)) > 0 {
+		t.Errorf("Expected the receiver's own output to be skipped")
+	}
+	if len(f.Format(Message{fromID: 2, buffer: []byte("connected\n"), system: true}, receiver)) > 0 {
+		t.Errorf("Expected system messages to be skipped")
+	}
+}
+
+func TestWriteAsciicast(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	var recording bytes.Buffer
+	for _, m := range []RecordedMessage{
+		{Time: start, ID: 2, System: true, Data: []byte("connected\n")},
+		{Time: start, ID: 1, Size: "100x30", Data: []byte("\x1b[8;30;100t")},
+		{Time: start.Add(time.Second), ID: 1, Data: []byte("$ ")},
+		{Time: start.Add(3 * time.Second), ID: 1, Size: "120x40", Data: []byte("\x1b[8;40;120t")},
+	} {
+		line, _ := json.Marshal(m)
+		recording.Write(append(line, '\n'))
+	}
+
+	var cast bytes.Buffer
+	if err := WriteAsciicast(&cast, &recording, "session", 2); err != nil {
+		t.Fatalf("Error writing asciicast: %s", err.Error())
+	}
+	lines := strings.Split(strings.TrimSpace(cast.String()), "\n")
+	expected := []string{
+		`{"version":2,"width":100,"height":30,"timestamp":1700000000,"idle_time_limit":2.5,"title":"session"}`,
+		`[0.5,"o","$ "]`,
+		`[1.5,"r","120x40"]`,
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Invalid asciicast: %q", lines)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"sort"
+)
+
+// the browser client - served at /<key> to browsers and /static/ for its assets
+//
+//go:embed web
+var webFiles embed.FS
+
+// staticHandler serves the embedded assets for the browser client
+func staticHandler() http.Handler {
+	files, _ := fs.Sub(webFiles, "web")
+	return http.StripPrefix("/static/", http.FileServer(http.FS(files)))
+}
+
+// acceptsHTML returns whether the request came from a browser asking for a page
+// curl sends */* so it still gets the text stream
+func acceptsHTML(r *http.Request) bool {
+	return negotiate(r, textType, htmlType) == htmlType
+}
+
+// wantsWebClient returns whether a browser opened the pipe url directly
+// any query parameters (?mode=file, ?format=raw etc.) go to the pipe itself
+func wantsWebClient(r *http.Request) bool {
+	return r.Method == "GET" && len(r.URL.RawQuery) == 0 && acceptsHTML(r)
+}
+
+// serve the browser client for a pipe
+func (s *server) webClient(w http.ResponseWriter, r *http.Request) {
+	page, err := webFiles.ReadFile("web/index.html")
+	if err != nil {
+		http.Error(w, "Web client not available", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Add("Vary", "Accept")
+	w.Write(page)
+}
+
+// connectedUser describes a receiver in the browser's user list
+type connectedUser struct {
+	ID   int    `json:"id"`
+	User string `json:"user"`
+}
+
+// list the receivers connected to a pipe as json
+func (s *server) users(w http.ResponseWriter, r *http.Request, p *params) {
+	users := []connectedUser{}
+	if pipe := s.allPipes.FindPipe(p.key); pipe != nil {
+		for _, receiver := range pipe.Receivers() {
+			users = append(users, connectedUser{ID: receiver.ID(), User: receiver.Username()})
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID < users[j].ID
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(users)
+}
@@ -0,0 +1,152 @@
+// browser client for a pipe
+// messages are received with server-sent events (?format=sse) and sent with a POST for each line
+// (in message mode so that the end of each POST doesn't close everyone's connection)
+(() => {
+	const key = location.pathname.slice(1);
+	const pipeURL = location.origin + '/' + key;
+	const output = document.getElementById('output');
+	const status = document.getElementById('status');
+	const username = document.getElementById('username');
+	const message = document.getElementById('message');
+	const users = document.getElementById('users');
+	const drop = document.getElementById('drop');
+	let events = null;
+
+	document.getElementById('pipe').textContent = pipeURL;
+	username.value = localStorage.getItem('username') || '';
+
+	function append(text, className) {
+		const line = document.createElement('span');
+		if (className) {
+			line.className = className;
+		}
+		// links to file pipes on this server can be clicked to download the file
+		// anything else anyone in the pipe sends stays plain text
+		const parts = text.split(/(https?:\/\/\S+)/);
+		parts.forEach((part, i) => {
+			const url = i % 2 === 0 ? null : fileURL(part);
+			if (!url) {
+				line.appendChild(document.createTextNode(part));
+				return;
+			}
+			const link = document.createElement('a');
+			link.href = url.href;
+			link.textContent = 'download';
+			link.download = '';
+			line.appendChild(link);
+		});
+		output.appendChild(line);
+		drop.scrollTop = drop.scrollHeight;
+	}
+
+	// the parsed url of a file pipe on this server or null for any other text
+	function fileURL(text) {
+		let url;
+		try {
+			url = new URL(text);
+		} catch (e) {
+			return null;
+		}
+		if (url.origin !== location.origin || url.searchParams.get('mode') !== 'file') {
+			return null;
+		}
+		return url;
+	}
+
+	// the text of a message - data that isn't valid utf-8 is sent as base64
+	function messageText(m) {
+		if (m.encoding !== 'base64') {
+			return m.data;
+		}
+		const bytes = Uint8Array.from(atob(m.data), c => c.charCodeAt(0));
+		return new TextDecoder().decode(bytes);
+	}
+
+	function query(params) {
+		const name = username.value.trim();
+		if (name) {
+			params.user = name;
+		}
+		return '?' + new URLSearchParams(params).toString();
+	}
+
+	function refreshUsers() {
+		fetch(pipeURL + '?users', {headers: {'Accept': 'application/json'}})
+			.then(response => response.json())
+			.then(list => {
+				users.textContent = '';
+				list.forEach(u => {
+					const item = document.createElement('li');
+					item.textContent = u.user || 'anonymous';
+					users.appendChild(item);
+				});
+			})
+			.catch(() => {});
+	}
+
+	function connect() {
+		if (events) {
+			events.close();
+		}
+		events = new EventSource(pipeURL + query({format: 'sse'}));
+		events.onopen = () => {
+			status.textContent = 'connected';
+			refreshUsers();
+		};
+		events.onerror = () => {
+			status.textContent = 'reconnecting';
+		};
+		events.addEventListener('message', e => {
+			const m = JSON.parse(e.data);
+			append((m.user ? m.user + ': ' : '') + messageText(m));
+		});
+		// connected and disconnected messages update the user list
+		events.addEventListener('system', refreshUsers);
+	}
+
+	function send(body) {
+		return fetch(pipeURL + query({msg: 1}), {method: 'POST', body: body});
+	}
+
+	document.getElementById('chat').onsubmit = e => {
+		e.preventDefault();
+		if (!message.value) {
+			return;
+		}
+		send(message.value + '\n');
+		message.value = '';
+	};
+
+	username.onchange = () => {
+		localStorage.setItem('username', username.value.trim());
+		connect();
+	};
+
+	// a dropped file is sent on its own one-shot file pipe and the link is shared in the chat
+	function sendFile(file) {
+		fetch(location.origin + '/new', {headers: {'Accept': 'text/plain'}})
+			.then(response => response.text())
+			.then(fileURL => {
+				fileURL = fileURL.trim() + '?mode=file&filename=' + encodeURIComponent(file.name);
+				append(`sending ${file.name} - waiting for someone to download it\n`, 'system');
+				send(`${file.name} (${file.size} bytes) ${fileURL}\n`);
+				return fetch(fileURL, {method: 'PUT', body: file, headers: {'Content-Type': file.type || 'application/octet-stream'}});
+			})
+			.then(response => response.text())
+			.then(result => append(`${file.name}: ${result}`, 'system'))
+			.catch(err => append(`${file.name}: ${err}\n`, 'system'));
+	}
+
+	drop.ondragover = e => {
+		e.preventDefault();
+		drop.classList.add('dragging');
+	};
+	drop.ondragleave = () => drop.classList.remove('dragging');
+	drop.ondrop = e => {
+		e.preventDefault();
+		drop.classList.remove('dragging');
+		Array.from(e.dataTransfer.files).forEach(sendFile);
+	};
+
+	connect();
+})();